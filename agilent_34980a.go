@@ -8,11 +8,14 @@ package instruments
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -26,23 +29,61 @@ const (
 )
 
 type Agilent34980A struct {
-	instr     *VisaObjectWrapper
+	instr     Instrument
 	pinsMap   map[int]int
 	relaysMap map[int]int
 }
 
+// Регистрация драйвера для OpenInstrument.
+func init() {
+	RegisterDriver(DriverInfo{
+		Name:         "Agilent 34980A",
+		Manufacturer: regexp.MustCompile(`(?i)^(agilent|keysight)`),
+		Model:        regexp.MustCompile(`(?i)^34980A$`),
+		New: func(instr Instrument) (Driver, error) {
+			sw := &Agilent34980A{instr: instr}
+			pinsNum := 0
+			for _, module := range sw.CheckSlots() {
+				if module == moduleDual4x16 {
+					pinsNum += pinsInModule
+				}
+			}
+			if pinsNum == 0 {
+				return nil, fmt.Errorf("no %s module found in Agilent 34980A slots", moduleDual4x16)
+			}
+			err := sw.Init(instr, pinsNum)
+			if err != nil {
+				return nil, err
+			}
+			return sw, nil
+		},
+	})
+}
+
 // Инициализация коммутатора
-func (sw *Agilent34980A) Init(instr *VisaObjectWrapper, pinsNum int) error {
+func (sw *Agilent34980A) Init(instr Instrument, pinsNum int) error {
+	return sw.InitContext(context.Background(), instr, pinsNum)
+}
+
+// Инициализация коммутатора с ограничением по времени и отменой через ctx.
+func (sw *Agilent34980A) InitContext(ctx context.Context, instr Instrument, pinsNum int) error {
 
 	sw.instr = instr
-	sw.instr.SetErrorQuery("SYST:ERR?")
-	err := sw.instr.Write("*RST")
+	ctx, unlock, err := lockInstr(ctx, instr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	instr = bindContext(ctx, instr)
+	instr.SetErrorQuery("SYST:ERR?")
+	err = instr.Write("*RST")
 	if err != nil {
 		return err
 	}
 	sw.pinsMap = make(map[int]int, pinsNum*moduleRowNum)
 	sw.relaysMap = make(map[int]int, pinsNum*moduleRowNum)
-	err = sw.fillPinArray(pinsNum)
+	err = sw.fillPinArray(ctx, pinsNum)
 	if err != nil {
 		return err
 	}
@@ -51,10 +92,22 @@ func (sw *Agilent34980A) Init(instr *VisaObjectWrapper, pinsNum int) error {
 
 // Проверка слотов коммутатора на наличие модулей внутри.
 func (sw *Agilent34980A) CheckSlots() [8]string {
+	return sw.CheckSlotsContext(context.Background())
+}
+
+// Проверка слотов коммутатора с ограничением по времени и отменой через ctx.
+func (sw *Agilent34980A) CheckSlotsContext(ctx context.Context) [8]string {
 
 	var moduleList [8]string
+	ctx, unlock, err := lockInstr(ctx, sw.instr)
+	if err != nil {
+		return moduleList
+	}
+	defer unlock()
+
+	instr := bindContext(ctx, sw.instr)
 	for i := 1; i <= len(moduleList); i++ {
-		result, _ := sw.instr.Query(fmt.Sprintf("SYSTem:CTYPe? %d", i))
+		result, _ := instr.Query(fmt.Sprintf("SYSTem:CTYPe? %d", i))
 		if len(result) == 0 {
 			moduleList[i-1] = "empty"
 		} else {
@@ -65,8 +118,30 @@ func (sw *Agilent34980A) CheckSlots() [8]string {
 	return moduleList
 }
 
+// Дождаться завершения операций коммутатора, не дольше timeout.
+func (sw *Agilent34980A) WaitForOPC(timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return sw.WaitForOPCContext(ctx)
+}
+
+// WaitForOPC с ограничением по времени и отменой через ctx.
+func (sw *Agilent34980A) WaitForOPCContext(ctx context.Context) error {
+	return waitForOPC(ctx, sw.instr)
+}
+
+// Закрыть сессию коммутатора.
+func (sw *Agilent34980A) Close() error {
+
+	if sw.instr == nil {
+		return nil
+	}
+	return sw.instr.Close()
+}
+
 // Создание перекодировочной таблицы для измерительной оснастки.
-func (sw *Agilent34980A) fillPinArray(totalPinsNum int) error {
+func (sw *Agilent34980A) fillPinArray(ctx context.Context, totalPinsNum int) error {
 
 	requiredNumOfModules := int(math.Ceil(float64(totalPinsNum) / pinsInModule))
 	excessPins := totalPinsNum % pinsInModule
@@ -78,7 +153,7 @@ func (sw *Agilent34980A) fillPinArray(totalPinsNum int) error {
 	}
 
 	// Get number of installed Agilent 34932A (Dual 4x16 Armature Matrix)
-	installedModules := sw.CheckSlots()
+	installedModules := sw.CheckSlotsContext(ctx)
 	var moduleDual4x16Number []int
 	moduleDual4x16Counter := 0
 
@@ -313,7 +388,13 @@ func (sw *Agilent34980A) RelaysToPins(relays []int) ([]int, error) {
 
 // Open/Close 34932A relays.
 func (sw *Agilent34980A) SetCommutation(pins []int, state bool) error {
+	return sw.SetCommutationContext(context.Background(), pins, state)
+}
+
+// SetCommutation с ограничением по времени и отменой через ctx.
+func (sw *Agilent34980A) SetCommutationContext(ctx context.Context, pins []int, state bool) error {
 
+	instr := bindContext(ctx, sw.instr)
 	var strState string
 	if state {
 		strState = "CLOSE"
@@ -324,7 +405,7 @@ func (sw *Agilent34980A) SetCommutation(pins []int, state bool) error {
 	if err != nil {
 		return errors.Wrap(err, "commutation failed")
 	}
-	err = sw.instr.Write(fmt.Sprintf("ROUT:%s (@%s)", strState, relayStr))
+	err = instr.Write(fmt.Sprintf("ROUT:%s (@%s)", strState, relayStr))
 	if err != nil {
 		return errors.Wrap(err, "commutation failed")
 	}
@@ -333,7 +414,13 @@ func (sw *Agilent34980A) SetCommutation(pins []int, state bool) error {
 
 // Get 34932A relay states.
 func (sw *Agilent34980A) GetCommutation(pins []int) ([]bool, error) {
+	return sw.GetCommutationContext(context.Background(), pins)
+}
+
+// GetCommutation с ограничением по времени и отменой через ctx.
+func (sw *Agilent34980A) GetCommutationContext(ctx context.Context, pins []int) ([]bool, error) {
 
+	instr := bindContext(ctx, sw.instr)
 	var errMsg = "failed to get pin states"
 	var states = make([]bool, len(pins))
 	var relayStr, stateStr string
@@ -344,7 +431,7 @@ func (sw *Agilent34980A) GetCommutation(pins []int) ([]bool, error) {
 		return nil, errors.Wrap(err, errMsg)
 	}
 
-	stateStr, err = sw.instr.Query(fmt.Sprintf("ROUTe:CLOSe? (@%s)", relayStr))
+	stateStr, err = instr.Query(fmt.Sprintf("ROUTe:CLOSe? (@%s)", relayStr))
 	if err != nil {
 		return nil, errors.Wrap(err, errMsg)
 	}
@@ -369,8 +456,14 @@ func (sw *Agilent34980A) GetCommutation(pins []int) ([]bool, error) {
 
 // Open all relays of 34932A modules installed in 34980A multifunction switch/measure mainframe.
 func (sw *Agilent34980A) OpenAllRelays() error {
+	return sw.OpenAllRelaysContext(context.Background())
+}
+
+// OpenAllRelays с ограничением по времени и отменой через ctx.
+func (sw *Agilent34980A) OpenAllRelaysContext(ctx context.Context) error {
 
-	err := sw.instr.Write("ROUT:OPEN:ALL ALL;*OPC")
+	instr := bindContext(ctx, sw.instr)
+	err := instr.Write("ROUT:OPEN:ALL ALL;*OPC")
 	if err != nil {
 		return err
 	}
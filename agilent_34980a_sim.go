@@ -0,0 +1,195 @@
+package instruments
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Agilent34980ASimulator is an in-process Agilent 34980A mainframe with 34932A matrix
+// modules. It implements Transport, so it can be used as Session{Transport: sim}.
+type Agilent34980ASimulator struct {
+	Slots  [8]string // module model per slot, "" for an empty slot
+	Serial string
+	scpiSimulator
+	closed map[int]bool
+}
+
+// Simulated mainframe with 34932A modules in first modules slots.
+func NewAgilent34980ASimulator(modules int) *Agilent34980ASimulator {
+
+	sim := &Agilent34980ASimulator{Serial: "MY44000000"}
+	for i := 0; i < modules && i < len(sim.Slots); i++ {
+		sim.Slots[i] = moduleDual4x16
+	}
+	sim.init()
+	return sim
+}
+
+func (sim *Agilent34980ASimulator) init() {
+
+	sim.closed = make(map[int]bool)
+	sim.handleCommon(func() string {
+		return fmt.Sprintf("Agilent Technologies,34980A,%s,2.41-2.41-2.41-2.41", sim.Serial)
+	}, func() {
+		sim.closed = make(map[int]bool)
+	})
+	sim.handle("SYSTem:CTYPe?", sim.cardType)
+	sim.handle("ROUTe:CLOSe", sim.setRelays(true))
+	sim.handle("ROUTe:OPEN", sim.setRelays(false))
+	sim.handle("ROUTe:CLOSe?", sim.relayStates(true))
+	sim.handle("ROUTe:OPEN?", sim.relayStates(false))
+	sim.handle("ROUTe:OPEN:ALL", sim.openAll)
+}
+
+func (sim *Agilent34980ASimulator) Open() error {
+
+	if sim.closed == nil {
+		sim.init()
+	}
+	return sim.scpiSimulator.Open()
+}
+
+// Preset relay state, all other relays are opened.
+func (sim *Agilent34980ASimulator) SetClosedRelays(relays []int) {
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.closed = make(map[int]bool, len(relays))
+	for _, relay := range relays {
+		sim.closed[relay] = true
+	}
+}
+
+// Closed relays (channel numbers) in ascending order.
+func (sim *Agilent34980ASimulator) ClosedRelays() []int {
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	var relays []int
+	for relay, closed := range sim.closed {
+		if closed {
+			relays = append(relays, relay)
+		}
+	}
+	sort.Ints(relays)
+	return relays
+}
+
+func (sim *Agilent34980ASimulator) cardType(args string) (string, error) {
+
+	slot, err := strconv.Atoi(args)
+	if err != nil {
+		return "", simError{code: scpiDataTypeError}
+	}
+	if slot < 1 || slot > len(sim.Slots) {
+		return "", simError{code: scpiDataOutOfRange}
+	}
+	if sim.Slots[slot-1] == "" {
+		return "Agilent Technologies,0,0,0", nil
+	}
+	return fmt.Sprintf("Agilent Technologies,%s,%s%d,1.04", sim.Slots[slot-1], sim.Serial, slot), nil
+}
+
+func (sim *Agilent34980ASimulator) setRelays(state bool) func(string) (string, error) {
+	return func(args string) (string, error) {
+		channels, err := sim.parseChannelList(args)
+		if err != nil {
+			return "", err
+		}
+		for _, channel := range channels {
+			sim.closed[channel] = state
+		}
+		return "", nil
+	}
+}
+
+func (sim *Agilent34980ASimulator) relayStates(closed bool) func(string) (string, error) {
+	return func(args string) (string, error) {
+		channels, err := sim.parseChannelList(args)
+		if err != nil {
+			return "", err
+		}
+		states := make([]string, len(channels))
+		for i, channel := range channels {
+			if sim.closed[channel] == closed {
+				states[i] = "1"
+			} else {
+				states[i] = "0"
+			}
+		}
+		return strings.Join(states, ","), nil
+	}
+}
+
+// ROUT:OPEN:ALL ALL|<slot>[,<slot>...]
+func (sim *Agilent34980ASimulator) openAll(args string) (string, error) {
+
+	if args == "" {
+		return "", simError{code: scpiMissingParameter}
+	}
+	if strings.EqualFold(args, "ALL") {
+		sim.closed = make(map[int]bool)
+		return "", nil
+	}
+	for _, field := range strings.Split(args, ",") {
+		slot, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || slot < 1 || slot > len(sim.Slots) {
+			return "", simError{code: scpiIllegalParameter}
+		}
+		for channel := range sim.closed {
+			if channel/1000 == slot {
+				delete(sim.closed, channel)
+			}
+		}
+	}
+	return "", nil
+}
+
+// Parse "(@1101:1116,2101)", ranges include valid channels only.
+func (sim *Agilent34980ASimulator) parseChannelList(args string) ([]int, error) {
+
+	if args == "" {
+		return nil, simError{code: scpiMissingParameter}
+	}
+	if !strings.HasPrefix(args, "(@") || !strings.HasSuffix(args, ")") {
+		return nil, simError{code: scpiDataTypeError}
+	}
+	var channels []int
+	for _, item := range strings.Split(args[2:len(args)-1], ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(item), ":")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, simError{code: scpiDataTypeError}
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil {
+				return nil, simError{code: scpiDataTypeError}
+			}
+		}
+		if !sim.validChannel(start) || !sim.validChannel(end) || end < start {
+			return nil, simError{code: scpiDataOutOfRange}
+		}
+		for channel := start; channel <= end; channel++ {
+			if sim.validChannel(channel) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels, nil
+}
+
+// 34932A channel is slot*1000 + row*100 + column, rows 1-8 (two 4x16 matrices), columns 1-16.
+func (sim *Agilent34980ASimulator) validChannel(channel int) bool {
+
+	slot, row, column := channel/1000, channel%1000/100, channel%100
+	if slot < 1 || slot > len(sim.Slots) || sim.Slots[slot-1] != moduleDual4x16 {
+		return false
+	}
+	return row >= 1 && row <= 2*moduleRowNum && column >= 1 && column <= moduleColNum
+}
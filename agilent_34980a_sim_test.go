@@ -0,0 +1,92 @@
+package instruments
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestAgilent34980aSimulated(t *testing.T) {
+
+	sim := NewAgilent34980ASimulator(2)
+	mtrxHandler := Session{Transport: sim}
+	err := mtrxHandler.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mtrxHandler.Close()
+
+	mtrx := Agilent34980A{}
+	err = mtrx.Init(&mtrxHandler, 2*pinsInModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slots := mtrx.CheckSlots()
+	if slots[0] != moduleDual4x16 || slots[1] != moduleDual4x16 || slots[2] == moduleDual4x16 {
+		t.Errorf("unexpected slots %v", slots)
+	}
+	if len(mtrx.pinsMap) != 2*pinsInModule*moduleRowNum {
+		t.Fatalf("unexpected pins map size %d", len(mtrx.pinsMap))
+	}
+
+	pins := []int{1001, 1002, 1003, 2010, 3033, 4064}
+	relays, err := mtrx.PinsToRelays(pins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backPins, err := mtrx.RelaysToPins(relays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(backPins) != fmt.Sprint(pins) {
+		t.Errorf("pins %v are mapped back as %v", pins, backPins)
+	}
+	if _, err = mtrx.PinsToRelays([]int{1065}); err == nil {
+		t.Error("pin out of configuration must not be mapped")
+	}
+
+	err = mtrx.SetCommutation(pins, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, err := mtrx.GetCommutation(pins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, state := range states {
+		if !state {
+			t.Errorf("pin %d is not commutated", pins[i])
+		}
+	}
+	sort.Ints(relays)
+	if fmt.Sprint(sim.ClosedRelays()) != fmt.Sprint(relays) {
+		t.Errorf("closed relays %v, expected %v", sim.ClosedRelays(), relays)
+	}
+
+	err = mtrx.SetCommutation(pins[0:1], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, err = mtrx.GetCommutation(pins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states[0] || !states[1] {
+		t.Errorf("unexpected states %v after opening pin %d", states, pins[0])
+	}
+
+	err = mtrx.OpenAllRelays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sim.ClosedRelays()) != 0 {
+		t.Errorf("relays %v are still closed", sim.ClosedRelays())
+	}
+
+	// Instr errors must be reported
+	err = mtrxHandler.Write("ROUT:CLOSE (@3101)")
+	if err == nil {
+		t.Error("closing relay of an empty slot must fail")
+	}
+}
@@ -1,6 +1,9 @@
+//go:build visa
+
 package instruments
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sort"
@@ -12,21 +15,26 @@ import (
 
 func TestAgilent34980a(t *testing.T) {
 
-	// loads values from .env into the system
-	if err := godotenv.Load(); err != nil {
-		t.Errorf("no .env file found")
-	}
-
-	// Get the AG34980A_IP_ADDR environment variable
-	addr, exists := os.LookupEnv("AG34980A_IP_ADDR")
-	if exists == false {
-		t.Errorf("AG34980A_IP_ADDR not exists")
-	}
+	// loads values from .env into the system, if any
+	godotenv.Load()
 
-	var fullAddr = fmt.Sprintf("TCPIP0::%s::INSTR", addr)
 	var manufacturer = "Agilent Technologies"
 	var model = "34980A"
 
+	// Get the AG34980A_IP_ADDR environment variable or discover the instr
+	// by model and optional AG34980A_SERIAL
+	var fullAddr string
+	addr, exists := os.LookupEnv("AG34980A_IP_ADDR")
+	if exists {
+		fullAddr = fmt.Sprintf("TCPIP0::%s::INSTR", addr)
+	} else {
+		var err error
+		fullAddr, err = FindInstrument(context.Background(), model, os.Getenv("AG34980A_SERIAL"))
+		if err != nil {
+			t.Fatalf("AG34980A_IP_ADDR not exists and discovery failed: %v", err)
+		}
+	}
+
 	rm, err := GetResourceManager()
 	if err != nil {
 		t.Errorf(err.Error())
@@ -40,8 +48,8 @@ func TestAgilent34980a(t *testing.T) {
 	}
 
 	instrInfo := mtrxHandler.GetInfo()
-	if instrInfo["Manufacturer"] != manufacturer ||
-		instrInfo["Model"] != model {
+	if instrInfo.Manufacturer != manufacturer ||
+		instrInfo.Model != model {
 		t.Errorf("instrument \"%s\" is not %s %s", fullAddr, manufacturer, model)
 	}
 
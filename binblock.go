@@ -0,0 +1,237 @@
+package instruments
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ByteOrder of REAL binary data, set on instr with FORM:BORD.
+type ByteOrder int
+
+const (
+	ByteOrderNormal  ByteOrder = iota // FORM:BORD NORM, big-endian
+	ByteOrderSwapped                  // FORM:BORD SWAP, little-endian
+)
+
+func (order ByteOrder) binaryOrder() binary.ByteOrder {
+
+	if order == ByteOrderSwapped {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// terminatedReader is implemented by transports splitting responses on a terminator,
+// a binary block containing it is read in several messages.
+type terminatedReader interface {
+	readTermination() []byte
+}
+
+// Parse IEEE-488.2 definite length block "#<n><len><data>" or indefinite length block
+// "#0<data>" at the start of msg. Data after a definite length block is returned as rest.
+func ParseBinaryBlock(msg []byte) (block, rest []byte, err error) {
+
+	size, start, err := binaryBlockHeader(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size < 0 {
+		return bytes.TrimRight(msg[start:], "\r\n"), nil, nil
+	}
+	if len(msg) < start+size {
+		return nil, nil, fmt.Errorf("binary block is truncated: %d of %d bytes", len(msg)-start, size)
+	}
+	return msg[start : start+size], msg[start+size:], nil
+}
+
+// Format data as definite length block "#<n><len><data>".
+func FormatBinaryBlock(data []byte) []byte {
+
+	length := strconv.Itoa(len(data))
+	block := make([]byte, 0, 2+len(length)+len(data))
+	block = append(block, '#', byte('0'+len(length)))
+	block = append(block, length...)
+	return append(block, data...)
+}
+
+// Parse block header, size is -1 for indefinite length block.
+func binaryBlockHeader(msg []byte) (size, start int, err error) {
+
+	trimmed := bytes.TrimLeft(msg, " ")
+	offset := len(msg) - len(trimmed)
+	msg = trimmed
+	if len(msg) < 2 || msg[0] != '#' || msg[1] < '0' || msg[1] > '9' {
+		return 0, 0, fmt.Errorf("response is not a binary block: %q", truncateForError(msg))
+	}
+	digits := int(msg[1] - '0')
+	if digits == 0 {
+		return -1, offset + 2, nil
+	}
+	if len(msg) < 2+digits {
+		return 0, 0, fmt.Errorf("binary block header is truncated: %q", truncateForError(msg))
+	}
+	size, err = strconv.Atoi(string(msg[2 : 2+digits]))
+	if err != nil || size < 0 {
+		return 0, 0, fmt.Errorf("invalid binary block length %q", msg[2:2+digits])
+	}
+	return size, offset + 2 + digits, nil
+}
+
+func truncateForError(msg []byte) []byte {
+
+	if len(msg) > 20 {
+		return msg[0:20]
+	}
+	return msg
+}
+
+// Decode REAL,32 data.
+func DecodeReal32(data []byte, order ByteOrder) ([]float32, error) {
+
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("REAL,32 data length %d is not a multiple of 4", len(data))
+	}
+	values := make([]float32, len(data)/4)
+	for i := range values {
+		values[i] = math.Float32frombits(order.binaryOrder().Uint32(data[4*i:]))
+	}
+	return values, nil
+}
+
+// Decode REAL,64 data.
+func DecodeReal64(data []byte, order ByteOrder) ([]float64, error) {
+
+	if len(data)%8 != 0 {
+		return nil, fmt.Errorf("REAL,64 data length %d is not a multiple of 8", len(data))
+	}
+	values := make([]float64, len(data)/8)
+	for i := range values {
+		values[i] = math.Float64frombits(order.binaryOrder().Uint64(data[8*i:]))
+	}
+	return values, nil
+}
+
+// Encode values as REAL,32 data.
+func EncodeReal32(values []float32, order ByteOrder) []byte {
+
+	data := make([]byte, 4*len(values))
+	for i, value := range values {
+		order.binaryOrder().PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// Encode values as REAL,64 data.
+func EncodeReal64(values []float64, order ByteOrder) []byte {
+
+	data := make([]byte, 8*len(values))
+	for i, value := range values {
+		order.binaryOrder().PutUint64(data[8*i:], math.Float64bits(value))
+	}
+	return data
+}
+
+// Write command to instr and read binary block response
+func (s *Session) QueryBinaryBlock(cmd string) ([]byte, error) {
+	return s.QueryBinaryBlockContext(context.Background(), cmd)
+}
+
+func (s *Session) QueryBinaryBlockContext(ctx context.Context, cmd string) ([]byte, error) {
+
+	var block []byte
+	err := s.withContext(ctx, cmd, func() error {
+		err := s.write(cmd)
+		if err != nil {
+			return err
+		}
+		block, err = s.readBinaryBlock(ctx, cmd)
+		return err
+	})
+	return block, err
+}
+
+// Write command followed by data as definite length block, e.g. "TRAC:DATA #18<data>"
+func (s *Session) WriteBinaryBlock(cmd string, data []byte) error {
+	return s.WriteBinaryBlockContext(context.Background(), cmd, data)
+}
+
+func (s *Session) WriteBinaryBlockContext(ctx context.Context, cmd string, data []byte) error {
+
+	msg := append([]byte(cmd+" "), FormatBinaryBlock(data)...)
+	return s.withContext(ctx, cmd, func() error {
+		err := s.ioWrite(msg)
+		if err != nil {
+			context := fmt.Sprintf("an error occurred while writing \"%s\" command", cmd)
+			return errors.Wrap(err, context)
+		}
+		instrErr := s.checkErrors(ctx, cmd)
+		if instrErr != nil {
+			context := fmt.Sprintf("an instr error occurred while writing \"%s\" command", cmd)
+			return errors.Wrap(instrErr, context)
+		}
+		return nil
+	})
+}
+
+// Write command to instr and read REAL,32 binary block response
+func (s *Session) QueryReal32(cmd string, order ByteOrder) ([]float32, error) {
+
+	block, err := s.QueryBinaryBlock(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeReal32(block, order)
+}
+
+// Write command to instr and read REAL,64 binary block response
+func (s *Session) QueryReal64(cmd string, order ByteOrder) ([]float64, error) {
+
+	block, err := s.QueryBinaryBlock(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeReal64(block, order)
+}
+
+// Read block response. A terminator inside block data splits it into several messages of
+// a terminated transport, they are joined back until the declared length is read.
+func (s *Session) readBinaryBlock(ctx context.Context, cmd string) ([]byte, error) {
+
+	msg, err := s.ioRead()
+	if err != nil {
+		if ctx.Err() == nil {
+			instrErr := s.checkErrors(ctx, cmd)
+			if instrErr != nil {
+				context := fmt.Sprintf("an instr error occurred while reading response after \"%s\" command", cmd)
+				return nil, errors.Wrap(instrErr, context)
+			}
+		}
+		context := fmt.Sprintf("an error occurred while reading response after \"%s\" command", cmd)
+		return nil, errors.Wrap(err, context)
+	}
+	size, start, err := binaryBlockHeader(msg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unexpected response after \"%s\" command", cmd)
+	}
+	if tr, ok := s.Transport.(terminatedReader); ok && size >= 0 {
+		for len(msg) < start+size {
+			next, err := s.ioRead()
+			if err != nil {
+				context := fmt.Sprintf("an error occurred while reading binary block after \"%s\" command", cmd)
+				return nil, errors.Wrap(err, context)
+			}
+			msg = append(append(msg, tr.readTermination()...), next...)
+		}
+	}
+	block, _, err := ParseBinaryBlock(msg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unexpected response after \"%s\" command", cmd)
+	}
+	return block, nil
+}
@@ -0,0 +1,91 @@
+package instruments
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBinaryBlock(t *testing.T) {
+
+	data := []byte("0123456789\nabc")
+	block := FormatBinaryBlock(data)
+	if string(block) != "#214"+string(data) {
+		t.Errorf("unexpected block %q", block)
+	}
+	parsed, rest, err := ParseBinaryBlock(append(block, '\n'))
+	if err != nil || !bytes.Equal(parsed, data) || string(rest) != "\n" {
+		t.Errorf("unexpected parse result %q, %q, %v", parsed, rest, err)
+	}
+	parsed, _, err = ParseBinaryBlock([]byte("#0abc\n"))
+	if err != nil || string(parsed) != "abc" {
+		t.Errorf("unexpected indefinite block %q, %v", parsed, err)
+	}
+	for _, msg := range []string{"", "1.0", "#", "#3", "#210abc", "#2xx"} {
+		_, _, err = ParseBinaryBlock([]byte(msg))
+		if err == nil {
+			t.Errorf("%q: expected parse error", msg)
+		}
+	}
+}
+
+func TestReal(t *testing.T) {
+
+	values32 := []float32{1.5, -2.25, 1e-9}
+	values64 := []float64{1.5, -2.25, 1e-9}
+	for _, order := range []ByteOrder{ByteOrderNormal, ByteOrderSwapped} {
+		decoded32, err := DecodeReal32(EncodeReal32(values32, order), order)
+		if err != nil || len(decoded32) != 3 || decoded32[2] != values32[2] {
+			t.Errorf("REAL,32 round trip failed: %v, %v", decoded32, err)
+		}
+		decoded64, err := DecodeReal64(EncodeReal64(values64, order), order)
+		if err != nil || len(decoded64) != 3 || decoded64[2] != values64[2] {
+			t.Errorf("REAL,64 round trip failed: %v, %v", decoded64, err)
+		}
+	}
+	if data := EncodeReal32([]float32{1}, ByteOrderNormal); !bytes.Equal(data, []byte{0x3F, 0x80, 0, 0}) {
+		t.Errorf("unexpected normal byte order %x", data)
+	}
+	if data := EncodeReal32([]float32{1}, ByteOrderSwapped); !bytes.Equal(data, []byte{0, 0, 0x80, 0x3F}) {
+		t.Errorf("unexpected swapped byte order %x", data)
+	}
+	_, err := DecodeReal64(make([]byte, 12), ByteOrderNormal)
+	if err == nil {
+		t.Error("expected length error")
+	}
+}
+
+func TestSessionLongResponses(t *testing.T) {
+
+	// Block with terminators inside and a text response much longer than a read chunk
+	data := EncodeReal32([]float32{1.5, 2.5, 3.5, 4.5}, ByteOrderSwapped)
+	data = append(data, "\n\n1\n"...)
+	channels := strings.Repeat("1,", 2000) + "1"
+	addr := startSocketServer(t, map[string]string{
+		"*IDN?":          "Keithley Instruments Inc.,Model 2400,1234567,C30",
+		"SYST:ERR?":      "+0,\"No error\"",
+		"TRAC:DATA?":     string(FormatBinaryBlock(data)),
+		"ROUT:CLOS? ALL": channels,
+	})
+	session := Session{Transport: &SocketTransport{Address: addr, Timeout: 5 * time.Second}}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.SetErrorQuery("SYST:ERR?")
+
+	block, err := session.QueryBinaryBlock("TRAC:DATA?")
+	if err != nil || !bytes.Equal(block, data) {
+		t.Fatalf("unexpected block %q, %v", block, err)
+	}
+	response, err := session.Query("ROUT:CLOS? ALL")
+	if err != nil || response != channels {
+		t.Errorf("long response is broken: %d bytes, %v", len(response), err)
+	}
+	_, err = session.QueryReal32("*IDN?", ByteOrderNormal)
+	if err == nil {
+		t.Error("expected error for non-block response")
+	}
+}
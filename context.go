@@ -0,0 +1,203 @@
+package instruments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DeadlineSetter is implemented by transports whose blocking I/O can be bounded and
+// interrupted: a deadline in the past aborts the operation in progress, zero removes it.
+type DeadlineSetter interface {
+	SetDeadline(t time.Time) error
+}
+
+// Clearer is implemented by transports able to issue a device clear.
+type Clearer interface {
+	Clear() error
+}
+
+// ContextInstrument is an Instrument whose calls honour context deadline and cancellation.
+type ContextInstrument interface {
+	Instrument
+	WriteContext(ctx context.Context, cmd string) error
+	WriteWithoutCheckContext(ctx context.Context, cmd string) error
+	QueryContext(ctx context.Context, cmd string) (string, error)
+	ReadBytesContext(ctx context.Context) ([]byte, error)
+	CheckErrorsContext(ctx context.Context) error
+}
+
+// TimeoutError is returned when instr doesn't respond before deadline.
+type TimeoutError struct {
+	Command string
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("instr timeout: %v", e.Err)
+	}
+	return fmt.Sprintf("instr timeout while executing \"%s\": %v", e.Command, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Check if err is caused by a timeout (network, file or VXI-11 I/O timeout).
+func isTimeout(err error) bool {
+
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// ioDeadline combines per-operation timeout of a transport with a deadline set by context.
+type ioDeadline struct {
+	mu    sync.Mutex
+	limit time.Time
+}
+
+// Set deadline now+timeout (or the limit, if earlier) with set, e.g. conn.SetReadDeadline.
+func (d *ioDeadline) apply(timeout time.Duration, set func(time.Time) error) error {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t := time.Now().Add(timeout)
+	if !d.limit.IsZero() && d.limit.Before(t) {
+		t = d.limit
+	}
+	return set(t)
+}
+
+// Remaining time of operation limited by timeout and the limit.
+func (d *ioDeadline) remaining(timeout time.Duration) time.Duration {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.limit.IsZero() {
+		return min(timeout, time.Until(d.limit))
+	}
+	return timeout
+}
+
+// Change the limit, an operation in progress is bounded by it immediately with set.
+func (d *ioDeadline) setLimit(t time.Time, set func(time.Time) error) error {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.limit = t
+	if t.IsZero() || set == nil {
+		return nil
+	}
+	return set(t)
+}
+
+// instrWithContext binds calls of instr to ctx, drivers use it in their *Context methods.
+type instrWithContext struct {
+	ctx   context.Context
+	instr Instrument
+}
+
+func bindContext(ctx context.Context, instr Instrument) Instrument {
+	return &instrWithContext{ctx: ctx, instr: instr}
+}
+
+func (ic *instrWithContext) Write(cmd string) error {
+
+	if ci, ok := ic.instr.(ContextInstrument); ok {
+		return ci.WriteContext(ic.ctx, cmd)
+	}
+	if err := ic.ctx.Err(); err != nil {
+		return contextError(err, cmd)
+	}
+	return ic.instr.Write(cmd)
+}
+
+func (ic *instrWithContext) WriteWithoutCheck(cmd string) error {
+
+	if ci, ok := ic.instr.(ContextInstrument); ok {
+		return ci.WriteWithoutCheckContext(ic.ctx, cmd)
+	}
+	if err := ic.ctx.Err(); err != nil {
+		return contextError(err, cmd)
+	}
+	return ic.instr.WriteWithoutCheck(cmd)
+}
+
+func (ic *instrWithContext) Query(cmd string) (string, error) {
+
+	if ci, ok := ic.instr.(ContextInstrument); ok {
+		return ci.QueryContext(ic.ctx, cmd)
+	}
+	if err := ic.ctx.Err(); err != nil {
+		return "", contextError(err, cmd)
+	}
+	return ic.instr.Query(cmd)
+}
+
+func (ic *instrWithContext) ReadBytes() ([]byte, error) {
+
+	if ci, ok := ic.instr.(ContextInstrument); ok {
+		return ci.ReadBytesContext(ic.ctx)
+	}
+	if err := ic.ctx.Err(); err != nil {
+		return nil, contextError(err, "")
+	}
+	return ic.instr.ReadBytes()
+}
+
+func (ic *instrWithContext) CheckErrors() error {
+
+	if ci, ok := ic.instr.(ContextInstrument); ok {
+		return ci.CheckErrorsContext(ic.ctx)
+	}
+	if err := ic.ctx.Err(); err != nil {
+		return contextError(err, "")
+	}
+	return ic.instr.CheckErrors()
+}
+
+func (ic *instrWithContext) SetErrorQuery(query string) {
+	ic.instr.SetErrorQuery(query)
+}
+
+func (ic *instrWithContext) Close() error {
+	return ic.instr.Close()
+}
+
+// Start transaction on instr if it supports locking, drivers use it to keep their command
+// sequences from interleaving with other users of the same instr.
+func lockInstr(ctx context.Context, instr Instrument) (context.Context, func(), error) {
+
+	locker, ok := instr.(Locker)
+	if !ok {
+		return ctx, func() {}, nil
+	}
+	ctx, err := locker.Lock(ctx)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return ctx, func() { locker.Unlock(ctx) }, nil
+}
+
+// Convert context error to TimeoutError (deadline) or wrapped context.Canceled.
+func contextError(err error, cmd string) error {
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{Command: cmd, Err: err}
+	}
+	if cmd == "" {
+		return errors.Wrap(err, "instr operation canceled")
+	}
+	return errors.Wrapf(err, "\"%s\" canceled", cmd)
+}
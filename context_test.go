@@ -0,0 +1,57 @@
+package instruments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionContext(t *testing.T) {
+
+	addr := startSocketServer(t, map[string]string{
+		"*IDN?":     "Agilent Technologies,34980A,MY44001234,2.41-2.41-2.41-2.41",
+		"SYST:ERR?": "+0,\"No error\"",
+	})
+	session := Session{Transport: &SocketTransport{Address: addr, Timeout: 5 * time.Second}}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.SetErrorQuery("SYST:ERR?")
+
+	// Deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = session.QueryContext(ctx, "HANG?")
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected TimeoutError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("deadline was not honoured, query took %s", elapsed)
+	}
+
+	// Cancellation of blocked read
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = session.QueryContext(ctx, "HANG?")
+	if !errors.Is(err, context.Canceled) || errors.As(err, &timeoutErr) {
+		t.Errorf("expected cancellation error, got %v", err)
+	}
+
+	// Session is usable after device clear
+	response, err := session.QueryContext(context.Background(), "*IDN?")
+	if err != nil || response != "Agilent Technologies,34980A,MY44001234,2.41-2.41-2.41-2.41" {
+		t.Errorf("session is broken after cancellation: %q, %v", response, err)
+	}
+
+	// Drivers refuse to start with done context
+	ke2400 := Keithley2400{}
+	err = ke2400.InitContext(ctx, &session)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation error from driver, got %v", err)
+	}
+}
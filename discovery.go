@@ -0,0 +1,565 @@
+package instruments
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultVISAFindExpr    = "(GPIB|TCPIP|USB)?*INSTR"
+	defaultDiscoveryWait   = time.Second
+	defaultIdentifyTimeout = 2 * time.Second
+	maxIdentifications     = 16 // instruments identified at once
+
+	mdnsAddress        = "224.0.0.251:5353"
+	dnsTypeA           = 1
+	dnsTypePTR         = 12
+	dnsTypeSRV         = 33
+	dnsClassIN         = 1
+	dnsUnicastResponse = 0x8000 // QU bit of question class
+	dnsFlagResponse    = 0x8000
+)
+
+// ErrInstrumentNotFound is returned by FindInstrument if no instr matches.
+var ErrInstrumentNotFound = errors.New("instrument not found")
+
+// DiscoveredInstrument is an instr found by Discover.
+type DiscoveredInstrument struct {
+	Resource string   // VISA resource string
+	Identity Identity // empty if identification failed
+	Err      error    // identification error
+}
+
+// Check identified instr model and serial, "MODEL " prefix and case of model are ignored
+// and empty serial matches any.
+func (di *DiscoveredInstrument) Matches(model, serial string) bool {
+
+	if di.Err != nil {
+		return false
+	}
+	trimModel := func(model string) string {
+		model = strings.TrimSpace(model)
+		if len(model) > 6 && strings.EqualFold(model[0:6], "model ") {
+			model = model[6:]
+		}
+		return model
+	}
+	return strings.EqualFold(trimModel(di.Identity.Model), trimModel(model)) &&
+		(serial == "" || di.Identity.Serial == serial)
+}
+
+// Discovery enumerates instruments with VISA viFindRsrc (build tag "visa"), VXI-11 portmapper
+// broadcast and mDNS browsing, then identifies every found resource with *IDN?.
+type Discovery struct {
+	VISAExpr        string        // viFindRsrc expression, "(GPIB|TCPIP|USB)?*INSTR" by default
+	SkipVISA        bool          // don't use VISA library, always skipped if it isn't compiled in
+	Broadcast       []string      // VXI-11 broadcast addresses, directed broadcasts of all interfaces by default
+	SkipVXI11       bool          // don't broadcast VXI-11 portmapper requests
+	MDNSServices    []string      // "_lxi._tcp", "_vxi-11._tcp", "_hislip._tcp" and "_scpi-raw._tcp" by default
+	SkipMDNS        bool          // don't browse mDNS services
+	Wait            time.Duration // time to collect broadcast and mDNS replies, 1 s by default
+	IdentifyTimeout time.Duration // connect and I/O timeout of identification, 2 s by default
+
+	mdnsAddress string // mDNS group address, overridden by tests
+}
+
+// Discover instruments with default Discovery.
+func Discover(ctx context.Context) ([]DiscoveredInstrument, error) {
+	return (&Discovery{}).Run(ctx)
+}
+
+// Discover instruments and return resource of the first one with given model and serial.
+func FindInstrument(ctx context.Context, model, serial string) (string, error) {
+	return (&Discovery{}).Find(ctx, model, serial)
+}
+
+// Find resources and identify them, instruments are sorted by resource.
+// Error is returned only if every enabled method failed.
+func (d *Discovery) Run(ctx context.Context) ([]DiscoveredInstrument, error) {
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		viaVISA  = map[string]bool{}
+		failures []string
+		methods  int
+	)
+	collect := func(method string, find func() ([]string, error), foundByVISA bool) {
+		methods++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resources, err := find()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", method, err))
+			}
+			for _, resource := range resources {
+				// Native transport is preferred if resource is found on the LAN as well
+				if found, ok := viaVISA[resource]; !ok || found {
+					viaVISA[resource] = foundByVISA
+				}
+			}
+		}()
+	}
+	if !d.SkipVISA && visaAvailable {
+		collect("VISA", func() ([]string, error) { return FindVISAResources(d.visaExpr()) }, true)
+	}
+	if !d.SkipVXI11 {
+		collect("VXI-11", func() ([]string, error) { return d.BrowseVXI11(ctx) }, false)
+	}
+	if !d.SkipMDNS {
+		collect("mDNS", func() ([]string, error) { return d.BrowseMDNS(ctx) }, false)
+	}
+	wg.Wait()
+	if len(viaVISA) == 0 && methods > 0 && len(failures) == methods {
+		return nil, fmt.Errorf("instrument discovery failed: %s", strings.Join(failures, "; "))
+	}
+
+	resources := make([]string, 0, len(viaVISA))
+	for resource := range viaVISA {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	instruments := make([]DiscoveredInstrument, len(resources))
+	sem := make(chan struct{}, maxIdentifications)
+	for i, resource := range resources {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			instruments[i] = d.identify(ctx, resource, viaVISA[resource])
+			<-sem
+		}()
+	}
+	wg.Wait()
+	return instruments, ctx.Err()
+}
+
+// Discover instruments and return resource of the first one with given model and serial,
+// see DiscoveredInstrument.Matches.
+func (d *Discovery) Find(ctx context.Context, model, serial string) (string, error) {
+
+	instruments, err := d.Run(ctx)
+	if err != nil {
+		return "", err
+	}
+	for i := range instruments {
+		if instruments[i].Matches(model, serial) {
+			return instruments[i].Resource, nil
+		}
+	}
+	if serial != "" {
+		return "", errors.Wrapf(ErrInstrumentNotFound, "%s %s", model, serial)
+	}
+	return "", errors.Wrap(ErrInstrumentNotFound, model)
+}
+
+// Open resource with a short timeout and query its identity.
+func (d *Discovery) identify(ctx context.Context, resource string, viaVISA bool) DiscoveredInstrument {
+
+	instr := DiscoveredInstrument{Resource: resource}
+	timeout := d.IdentifyTimeout
+	if timeout == 0 {
+		timeout = defaultIdentifyTimeout
+	}
+	var transport Transport
+	if viaVISA {
+		transport = newVISATransport(resource)
+	} else {
+		res, err := ParseResource(resource)
+		if err != nil {
+			instr.Err = err
+			return instr
+		}
+		transport = res.transport()
+		switch t := transport.(type) {
+		case *SocketTransport:
+			t.Timeout = timeout
+		case *VXI11Transport:
+			t.Timeout = timeout
+		case *HiSLIPTransport:
+			t.Timeout = timeout
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	session := &Session{Transport: transport}
+	err := session.InitContext(ctx)
+	session.Close()
+	if err != nil {
+		instr.Err = errors.Wrapf(err, "couldn't identify \"%s\"", resource)
+		return instr
+	}
+	instr.Identity = session.GetInfo()
+	return instr
+}
+
+func (d *Discovery) visaExpr() string {
+
+	if d.VISAExpr == "" {
+		return defaultVISAFindExpr
+	}
+	return d.VISAExpr
+}
+
+// Broadcast portmapper GETPORT request for VXI-11 core channel (ONC RPC over UDP)
+// and return resources of the hosts which have it registered.
+func (d *Discovery) BrowseVXI11(ctx context.Context) ([]string, error) {
+
+	addresses := d.Broadcast
+	if len(addresses) == 0 {
+		addresses = broadcastAddresses()
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open UDP socket")
+	}
+	defer conn.Close()
+
+	xid := rpcXid.Add(1) << 16
+	args := xdrWriter{}
+	args.uint32(vxi11CoreProgram)
+	args.uint32(vxi11CoreVersion)
+	args.uint32(ipProtoTCP)
+	args.uint32(0)
+	request := rpcCallMessage(xid, portmapperProgram, portmapperVersion, portmapperGetPort, args.buf)
+	var sendErr error
+	sent := 0
+	for _, address := range addresses {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, strconv.Itoa(portmapperPort))
+		}
+		udpAddr, err := net.ResolveUDPAddr("udp4", address)
+		if err == nil {
+			_, err = conn.WriteTo(request, udpAddr)
+		}
+		if err != nil {
+			sendErr = err
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return nil, errors.Wrap(sendErr, "VXI-11 broadcast failed")
+	}
+
+	var resources []string
+	err = d.receive(ctx, conn, func(packet []byte, from *net.UDPAddr) {
+		reply, ok, err := parseRPCReply(packet, xid, portmapperGetPort)
+		if !ok || err != nil {
+			return
+		}
+		port := reply.uint32()
+		if reply.err != nil || port == 0 {
+			return
+		}
+		resource := (&VXI11Resource{Host: from.IP.String(), Device: "inst0"}).String()
+		if !containsString(resources, resource) {
+			resources = append(resources, resource)
+		}
+	})
+	return resources, err
+}
+
+// Browse mDNS services of instruments and return their resources: VXI-11 ones for LXI
+// and VXI-11 services, HiSLIP and raw socket ones for HiSLIP and SCPI raw services.
+func (d *Discovery) BrowseMDNS(ctx context.Context) ([]string, error) {
+
+	services := []string{"_lxi._tcp", "_vxi-11._tcp", "_hislip._tcp", "_scpi-raw._tcp"}
+	if len(d.MDNSServices) > 0 {
+		services = make([]string, len(d.MDNSServices))
+		for i, service := range d.MDNSServices {
+			services[i] = strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(service, "."), ".local"))
+		}
+	}
+	address := d.mdnsAddress
+	if address == "" {
+		address = mdnsAddress
+	}
+	groupAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	// Query from an ephemeral port is answered with unicast (RFC 6762, 6.7)
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open UDP socket")
+	}
+	defer conn.Close()
+
+	browser := newMDNSBrowser()
+	query := []byte{0, 0, 0, 0} // id, flags
+	query = binary.BigEndian.AppendUint16(query, uint16(len(services)))
+	query = append(query, 0, 0, 0, 0, 0, 0)
+	for _, service := range services {
+		query = appendDNSName(query, service+".local")
+		query = binary.BigEndian.AppendUint16(query, dnsTypePTR)
+		query = binary.BigEndian.AppendUint16(query, dnsClassIN|dnsUnicastResponse)
+	}
+	_, err = conn.WriteTo(query, groupAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "mDNS query failed")
+	}
+	err = d.receive(ctx, conn, browser.handle)
+	return browser.resources(services), err
+}
+
+// Pass datagrams received during Wait to handle.
+func (d *Discovery) receive(ctx context.Context, conn *net.UDPConn, handle func(packet []byte, from *net.UDPAddr)) error {
+
+	wait := d.Wait
+	if wait == 0 {
+		wait = defaultDiscoveryWait
+	}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if isTimeout(err) {
+				return nil
+			}
+			return err
+		}
+		handle(buf[0:n], from)
+	}
+}
+
+// Directed broadcast addresses of IPv4 interfaces which are up.
+func broadcastAddresses() []string {
+
+	var addresses []string
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			mask := ipNet.Mask[len(ipNet.Mask)-4:]
+			broadcast := make(net.IP, 4)
+			for i := range broadcast {
+				broadcast[i] = ip[i] | ^mask[i]
+			}
+			addresses = append(addresses, broadcast.String())
+		}
+	}
+	if len(addresses) == 0 {
+		addresses = append(addresses, net.IPv4bcast.String())
+	}
+	return addresses
+}
+
+func containsString(list []string, s string) bool {
+
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mdnsBrowser collects PTR, SRV and A records of mDNS responses.
+type mdnsBrowser struct {
+	instances map[string][]string        // service name -> instance names
+	targets   map[string]mdnsServiceInfo // instance name -> service host and port
+	hosts     map[string]net.IP          // host name -> IPv4 address
+}
+
+type mdnsServiceInfo struct {
+	target string
+	port   int
+	from   net.IP // responder address, used if host address isn't reported
+}
+
+func newMDNSBrowser() *mdnsBrowser {
+
+	return &mdnsBrowser{
+		instances: map[string][]string{},
+		targets:   map[string]mdnsServiceInfo{},
+		hosts:     map[string]net.IP{},
+	}
+}
+
+func (b *mdnsBrowser) handle(packet []byte, from *net.UDPAddr) {
+
+	r := &dnsReader{msg: packet}
+	r.uint16() // id
+	if r.uint16()&dnsFlagResponse == 0 {
+		return
+	}
+	questions := int(r.uint16())
+	records := int(r.uint16()) + int(r.uint16()) + int(r.uint16())
+	for i := 0; i < questions; i++ {
+		r.name()
+		r.skip(4) // type, class
+	}
+	for i := 0; i < records && r.err == nil; i++ {
+		name := r.name()
+		rrType := r.uint16()
+		r.skip(6) // class, TTL
+		end := r.off + int(r.uint16())
+		if r.err != nil || end > len(packet) {
+			return
+		}
+		switch rrType {
+		case dnsTypePTR:
+			instance := r.name()
+			if r.err == nil && !containsString(b.instances[name], instance) {
+				b.instances[name] = append(b.instances[name], instance)
+			}
+		case dnsTypeSRV:
+			r.skip(4) // priority, weight
+			port := int(r.uint16())
+			target := r.name()
+			if r.err == nil {
+				b.targets[name] = mdnsServiceInfo{target: target, port: port, from: from.IP}
+			}
+		case dnsTypeA:
+			if end-r.off == 4 {
+				b.hosts[name] = net.IP(append([]byte(nil), packet[r.off:end]...))
+			}
+		}
+		r.off = end
+	}
+}
+
+// Resources of service instances with known SRV records.
+func (b *mdnsBrowser) resources(services []string) []string {
+
+	var resources []string
+	for _, service := range services {
+		instances := b.instances[service+".local"]
+		sort.Strings(instances)
+		for _, instance := range instances {
+			info, ok := b.targets[instance]
+			if !ok {
+				continue
+			}
+			ip := b.hosts[info.target]
+			if ip == nil {
+				ip = info.from
+			}
+			var res Resource
+			switch service {
+			case "_scpi-raw._tcp":
+				res = &SocketResource{Host: ip.String(), Port: info.port}
+			case "_hislip._tcp":
+				res = &HiSLIPResource{Host: ip.String(), SubAddress: "hislip0", Port: info.port}
+			default:
+				res = &VXI11Resource{Host: ip.String(), Device: "inst0"}
+			}
+			if !containsString(resources, res.String()) {
+				resources = append(resources, res.String())
+			}
+		}
+	}
+	return resources
+}
+
+func appendDNSName(msg []byte, name string) []byte {
+
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// dnsReader parses DNS message (RFC 1035), names are lowercased and have no trailing dot.
+type dnsReader struct {
+	msg []byte
+	off int
+	err error
+}
+
+func (r *dnsReader) uint16() uint16 {
+
+	if r.err != nil {
+		return 0
+	}
+	if r.off+2 > len(r.msg) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.msg[r.off:])
+	r.off += 2
+	return v
+}
+
+func (r *dnsReader) skip(n int) {
+
+	if r.err == nil && r.off+n > len(r.msg) {
+		r.err = io.ErrUnexpectedEOF
+	}
+	r.off += n
+}
+
+func (r *dnsReader) name() string {
+
+	if r.err != nil {
+		return ""
+	}
+	var labels []string
+	off := r.off
+	jumped := false
+	for hops := 0; hops < len(r.msg); hops++ {
+		if off >= len(r.msg) {
+			break
+		}
+		length := int(r.msg[off])
+		switch {
+		case length == 0:
+			if !jumped {
+				r.off = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, "."))
+		case length&0xC0 == 0xC0:
+			if off+2 > len(r.msg) {
+				r.err = io.ErrUnexpectedEOF
+				return ""
+			}
+			if !jumped {
+				r.off = off + 2
+			}
+			jumped = true
+			off = int(binary.BigEndian.Uint16(r.msg[off:]) & 0x3FFF)
+		case length&0xC0 != 0:
+			r.err = fmt.Errorf("unsupported DNS label type %#x", length&0xC0)
+			return ""
+		default:
+			if off+1+length > len(r.msg) {
+				r.err = io.ErrUnexpectedEOF
+				return ""
+			}
+			labels = append(labels, string(r.msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+	r.err = errors.New("malformed DNS name")
+	return ""
+}
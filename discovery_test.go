@@ -0,0 +1,188 @@
+package instruments
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Start UDP stand-in answering every datagram with reply(request), no answer if reply is nil.
+func startUDPResponder(t *testing.T, address string, reply func(request []byte) []byte) string {
+
+	conn, err := net.ListenPacket("udp4", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := reply(buf[0:n]); response != nil {
+				conn.WriteTo(response, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// Portmapper stand-in replying to GETPORT of VXI-11 core channel with port.
+func portmapperReply(port uint32) func(request []byte) []byte {
+
+	return func(request []byte) []byte {
+		call := &xdrReader{buf: request}
+		xid := call.uint32()
+		call.uint32() // call
+		call.uint32() // RPC version
+		if call.uint32() != portmapperProgram || call.uint32() != portmapperVersion || call.uint32() != portmapperGetPort {
+			return nil
+		}
+		call.uint32() // credentials
+		call.opaque()
+		call.uint32() // verifier
+		call.opaque()
+		if call.uint32() != vxi11CoreProgram || call.err != nil {
+			return nil
+		}
+		reply := xdrWriter{}
+		reply.uint32(xid)
+		reply.uint32(rpcReply)
+		reply.uint32(rpcMsgAccepted)
+		reply.uint32(0) // verifier AUTH_NONE
+		reply.uint32(0)
+		reply.uint32(rpcSuccess)
+		reply.uint32(port)
+		return reply.buf
+	}
+}
+
+func TestBrowseVXI11(t *testing.T) {
+
+	registered := startUDPResponder(t, "127.0.0.1:0", portmapperReply(1024))
+	unregistered := startUDPResponder(t, "127.0.0.2:0", portmapperReply(0))
+	discovery := Discovery{Broadcast: []string{registered, unregistered}, Wait: 200 * time.Millisecond}
+	resources, err := discovery.BrowseVXI11(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0] != "TCPIP0::127.0.0.1::inst0::INSTR" {
+		t.Errorf("unexpected resources %v", resources)
+	}
+}
+
+type dnsTestRecord struct {
+	name   []byte // encoded name
+	rrType uint16
+	data   []byte
+}
+
+func dnsResponse(records ...dnsTestRecord) []byte {
+
+	msg := []byte{0, 0, 0x84, 0} // id, flags: response, authoritative
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(records)))
+	msg = append(msg, 0, 0, 0, 0)
+	for _, record := range records {
+		msg = append(msg, record.name...)
+		msg = binary.BigEndian.AppendUint16(msg, record.rrType)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+		msg = binary.BigEndian.AppendUint32(msg, 120)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(record.data)))
+		msg = append(msg, record.data...)
+	}
+	return msg
+}
+
+func dnsSRV(port uint16, target string) []byte {
+	return appendDNSName(binary.BigEndian.AppendUint16([]byte{0, 0, 0, 0}, port), target)
+}
+
+// mDNS stand-in advertising raw SCPI service of K2400 at socketPort (host address isn't
+// reported, instance name is compressed) and LXI service of a 34980A.
+func mdnsReply(socketPort uint16) func(request []byte) []byte {
+
+	return func(request []byte) []byte {
+		query := &dnsReader{msg: request}
+		query.skip(4)
+		questions := int(query.uint16())
+		query.skip(6)
+		var names []string
+		for i := 0; i < questions; i++ {
+			names = append(names, query.name())
+			query.skip(4)
+		}
+		if query.err != nil || len(names) != 2 || names[0] != "_scpi-raw._tcp.local" || names[1] != "_lxi._tcp.local" {
+			return nil
+		}
+		return dnsResponse(
+			dnsTestRecord{appendDNSName(nil, "_scpi-raw._tcp.local"), dnsTypePTR, []byte("\x05K2400\xc0\x0c")},
+			dnsTestRecord{appendDNSName(nil, "k2400._scpi-raw._tcp.local"), dnsTypeSRV, dnsSRV(socketPort, "k2400.local")},
+			dnsTestRecord{appendDNSName(nil, "_lxi._tcp.local"), dnsTypePTR, appendDNSName(nil, "Agilent 34980A._lxi._tcp.local")},
+			dnsTestRecord{appendDNSName(nil, "Agilent 34980A._lxi._tcp.local"), dnsTypeSRV, dnsSRV(80, "a34980a.local")},
+			dnsTestRecord{appendDNSName(nil, "A34980A.local"), dnsTypeA, []byte{192, 0, 2, 10}},
+		)
+	}
+}
+
+func TestBrowseMDNS(t *testing.T) {
+
+	discovery := Discovery{
+		MDNSServices: []string{"_scpi-raw._tcp.local.", "_lxi._tcp"},
+		Wait:         200 * time.Millisecond,
+		mdnsAddress:  startUDPResponder(t, "127.0.0.1:0", mdnsReply(5025)),
+	}
+	resources, err := discovery.BrowseMDNS(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"TCPIP0::127.0.0.1::5025::SOCKET", "TCPIP0::192.0.2.10::inst0::INSTR"}
+	if len(resources) != len(expected) || resources[0] != expected[0] || resources[1] != expected[1] {
+		t.Errorf("unexpected resources %v", resources)
+	}
+
+	browser := newMDNSBrowser()
+	for _, packet := range [][]byte{{}, {0, 0, 0x84, 0, 0, 0, 0, 1}, dnsResponse(dnsTestRecord{[]byte{0xc0, 0x0c}, dnsTypePTR, nil})} {
+		browser.handle(packet, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	}
+	if len(browser.instances) != 0 {
+		t.Errorf("malformed responses must be ignored, got %v", browser.instances)
+	}
+}
+
+func TestDiscoveryRun(t *testing.T) {
+
+	server := startDroppingServer(t, "KEITHLEY INSTRUMENTS INC.,MODEL 2400,1234567,C30   Mar 17 2006 09:29:29/A02  /K/J")
+	_, port, _ := net.SplitHostPort(server.addr)
+	socketPort, _ := net.LookupPort("tcp", port)
+	discovery := Discovery{
+		SkipVISA:        true,
+		SkipVXI11:       true,
+		MDNSServices:    []string{"_scpi-raw._tcp", "_lxi._tcp"},
+		Wait:            200 * time.Millisecond,
+		IdentifyTimeout: 200 * time.Millisecond,
+		mdnsAddress:     startUDPResponder(t, "127.0.0.1:0", mdnsReply(uint16(socketPort))),
+	}
+	instruments, err := discovery.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instruments) != 2 || instruments[0].Err != nil || instruments[0].Identity.Serial != "1234567" ||
+		instruments[1].Err == nil {
+		t.Fatalf("unexpected instruments %+v", instruments)
+	}
+
+	resource, err := discovery.Find(context.Background(), "2400", "1234567")
+	if err != nil || resource != instruments[0].Resource {
+		t.Errorf("unexpected resource \"%s\", error %v", resource, err)
+	}
+	_, err = discovery.Find(context.Background(), "model 2400", "7654321")
+	if !errors.Is(err, ErrInstrumentNotFound) {
+		t.Errorf("expected ErrInstrumentNotFound, got %v", err)
+	}
+}
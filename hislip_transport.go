@@ -0,0 +1,588 @@
+package instruments
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HiSLIP, see IVI-6.1 High-Speed LAN Instrument Protocol rev 2.0
+const (
+	hislipPort            = 4880
+	hislipHeaderSize      = 16
+	hislipProtocolVersion = 0x0100
+	hislipVendorID        = 0x474F // "GO"
+	hislipFirstMessageID  = 0xFFFFFF00
+	hislipNoMessageID     = hislipFirstMessageID - 2 // last sent ID before the first message
+	hislipMaxMessageSize  = 1 << 20
+
+	hislipInitialize                      = 0
+	hislipInitializeResponse              = 1
+	hislipFatalError                      = 2
+	hislipError                           = 3
+	hislipAsyncLock                       = 4
+	hislipAsyncLockResponse               = 5
+	hislipData                            = 6
+	hislipDataEnd                         = 7
+	hislipDeviceClearComplete             = 8
+	hislipDeviceClearAcknowledge          = 9
+	hislipAsyncRemoteLocalControl         = 10
+	hislipAsyncRemoteLocalResponse        = 11
+	hislipTrigger                         = 12
+	hislipInterrupted                     = 13
+	hislipAsyncInterrupted                = 14
+	hislipAsyncMaximumMessageSize         = 15
+	hislipAsyncMaximumMessageSizeResponse = 16
+	hislipAsyncInitialize                 = 17
+	hislipAsyncInitializeResponse         = 18
+	hislipAsyncDeviceClear                = 19
+	hislipAsyncServiceRequest             = 20
+	hislipAsyncStatusQuery                = 21
+	hislipAsyncStatusResponse             = 22
+	hislipAsyncDeviceClearAcknowledge     = 23
+
+	hislipRemoteGotoLocal    = 2
+	hislipRemoteGotoRemote   = 3
+	hislipDefaultTimeout     = 10 * time.Second
+	hislipServiceRequestSize = 16
+)
+
+type hislipMessage struct {
+	msgType byte
+	control byte
+	param   uint32
+	payload []byte
+}
+
+func writeHislip(w io.Writer, msg hislipMessage) error {
+
+	buf := make([]byte, hislipHeaderSize, hislipHeaderSize+len(msg.payload))
+	buf[0], buf[1] = 'H', 'S'
+	buf[2] = msg.msgType
+	buf[3] = msg.control
+	binary.BigEndian.PutUint32(buf[4:8], msg.param)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(len(msg.payload)))
+	_, err := w.Write(append(buf, msg.payload...))
+	return err
+}
+
+func readHislip(r io.Reader) (hislipMessage, error) {
+
+	header := make([]byte, hislipHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return hislipMessage{}, err
+	}
+	if header[0] != 'H' || header[1] != 'S' {
+		return hislipMessage{}, fmt.Errorf("HiSLIP message has invalid prologue %q", header[0:2])
+	}
+	length := binary.BigEndian.Uint64(header[8:16])
+	if length > 1<<31 {
+		return hislipMessage{}, fmt.Errorf("HiSLIP payload length %d is too large", length)
+	}
+	msg := hislipMessage{
+		msgType: header[2],
+		control: header[3],
+		param:   binary.BigEndian.Uint32(header[4:8]),
+		payload: make([]byte, length),
+	}
+	_, err = io.ReadFull(r, msg.payload)
+	return msg, err
+}
+
+// HiSLIPError is an Error or FatalError message sent by a HiSLIP server.
+type HiSLIPError struct {
+	Fatal   bool
+	Code    byte
+	Message string
+}
+
+func (e *HiSLIPError) Error() string {
+	if e.Fatal {
+		return fmt.Sprintf("HiSLIP fatal error %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("HiSLIP error %d: %s", e.Code, e.Message)
+}
+
+// HiSLIPTransport implements Transport over HiSLIP (TCPIP::host::hislip0::INSTR) without VISA.
+type HiSLIPTransport struct {
+	Host       string        // instrument host name or IP address
+	SubAddress string        // HiSLIP sub-address, "hislip0" by default
+	Port       int           // 4880 by default
+	Timeout    time.Duration // I/O timeout, 10 s by default
+	Overlapped bool          // request overlapped mode instead of synchronized one
+
+	syncConn       net.Conn
+	asyncConn      net.Conn
+	sessionID      uint16
+	overlapped     bool
+	maxMessageSize uint64
+	messageID      uint32
+	lastSentID     uint32
+	rmtDelivered   bool
+	asyncMu        sync.Mutex
+	asyncReplies   chan hislipMessage
+	serviceRequest chan byte
+	asyncErr       error         // set by async reader before asyncReplies is closed
+	asyncDone      chan struct{} // closed when async reader exits
+	deadline       ioDeadline
+	broken         bool
+}
+
+func (ht *HiSLIPTransport) Open() error {
+
+	if ht.SubAddress == "" {
+		ht.SubAddress = "hislip0"
+	}
+	if ht.Port == 0 {
+		ht.Port = hislipPort
+	}
+	if ht.Timeout == 0 {
+		ht.Timeout = hislipDefaultTimeout
+	}
+	address := net.JoinHostPort(ht.Host, strconv.Itoa(ht.Port))
+
+	// Synchronous channel
+	syncConn, err := net.DialTimeout("tcp", address, ht.Timeout)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't connect to HiSLIP server \"%s\"", address)
+	}
+	syncConn.SetDeadline(time.Now().Add(ht.Timeout))
+	err = writeHislip(syncConn, hislipMessage{
+		msgType: hislipInitialize,
+		param:   hislipProtocolVersion<<16 | hislipVendorID,
+		payload: []byte(ht.SubAddress),
+	})
+	if err != nil {
+		syncConn.Close()
+		return err
+	}
+	reply, err := ht.expect(syncConn, hislipInitializeResponse)
+	if err != nil {
+		syncConn.Close()
+		return errors.Wrapf(err, "HiSLIP initialization of \"%s\" failed", address)
+	}
+	ht.sessionID = uint16(reply.param)
+	ht.overlapped = reply.control&1 != 0
+
+	// Asynchronous channel
+	asyncConn, err := net.DialTimeout("tcp", address, ht.Timeout)
+	if err != nil {
+		syncConn.Close()
+		return errors.Wrapf(err, "couldn't connect to HiSLIP server \"%s\"", address)
+	}
+	asyncConn.SetDeadline(time.Now().Add(ht.Timeout))
+	err = writeHislip(asyncConn, hislipMessage{msgType: hislipAsyncInitialize, param: uint32(ht.sessionID)})
+	if err == nil {
+		_, err = ht.expect(asyncConn, hislipAsyncInitializeResponse)
+	}
+	if err == nil {
+		maxSize := binary.BigEndian.AppendUint64(nil, hislipMaxMessageSize)
+		err = writeHislip(asyncConn, hislipMessage{msgType: hislipAsyncMaximumMessageSize, payload: maxSize})
+	}
+	if err == nil {
+		reply, err = ht.expect(asyncConn, hislipAsyncMaximumMessageSizeResponse)
+	}
+	if err != nil {
+		syncConn.Close()
+		asyncConn.Close()
+		return errors.Wrapf(err, "HiSLIP async channel initialization of \"%s\" failed", address)
+	}
+	ht.maxMessageSize = hislipMaxMessageSize
+	if len(reply.payload) == 8 {
+		ht.maxMessageSize = min(ht.maxMessageSize, binary.BigEndian.Uint64(reply.payload))
+	}
+	asyncConn.SetDeadline(time.Time{})
+
+	ht.syncConn = syncConn
+	ht.asyncConn = asyncConn
+	ht.broken = false
+	ht.messageID = hislipFirstMessageID
+	ht.lastSentID = hislipNoMessageID
+	ht.rmtDelivered = false
+	ht.asyncErr = nil
+	ht.asyncReplies = make(chan hislipMessage, 1)
+	ht.serviceRequest = make(chan byte, hislipServiceRequestSize)
+	ht.asyncDone = make(chan struct{})
+	go ht.readAsync(asyncConn, ht.asyncReplies, ht.serviceRequest, ht.asyncDone)
+
+	if ht.Overlapped != ht.overlapped {
+		err = ht.Clear()
+		if err != nil {
+			ht.Close()
+			return errors.Wrapf(err, "HiSLIP mode negotiation with \"%s\" failed", address)
+		}
+	}
+	return nil
+}
+
+// Write message as Data messages with DataEnd on the last one.
+func (ht *HiSLIPTransport) Write(data []byte) error {
+
+	err := ht.recover()
+	if err != nil {
+		return err
+	}
+	err = ht.deadline.apply(ht.Timeout, ht.syncConn.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	chunkSize := int(ht.maxMessageSize - hislipHeaderSize)
+	for {
+		msg := hislipMessage{msgType: hislipDataEnd, param: ht.messageID, payload: data}
+		if len(data) > chunkSize {
+			msg.msgType = hislipData
+			msg.payload = data[0:chunkSize]
+		}
+		if ht.rmtDelivered {
+			msg.control = 1
+			ht.rmtDelivered = false
+		}
+		err = writeHislip(ht.syncConn, msg)
+		if err != nil {
+			ht.broken = true
+			return err
+		}
+		data = data[len(msg.payload):]
+		if msg.msgType == hislipDataEnd {
+			break
+		}
+	}
+	ht.lastSentID = ht.messageID
+	ht.messageID += 2
+	return nil
+}
+
+// Read Data messages up to DataEnd. In synchronized mode responses to earlier messages are discarded.
+func (ht *HiSLIPTransport) Read() ([]byte, error) {
+
+	err := ht.recover()
+	if err != nil {
+		return nil, err
+	}
+	err = ht.deadline.apply(ht.Timeout, ht.syncConn.SetReadDeadline)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for {
+		msg, err := readHislip(ht.syncConn)
+		if err != nil {
+			ht.broken = true
+			return nil, err
+		}
+		switch msg.msgType {
+		case hislipData, hislipDataEnd:
+			data = append(data, msg.payload...)
+			if msg.msgType == hislipData {
+				continue
+			}
+			if !ht.overlapped && msg.param != ht.lastSentID {
+				data = nil
+				continue
+			}
+			ht.rmtDelivered = true
+			return data, nil
+		case hislipInterrupted:
+			data = nil
+		case hislipError, hislipFatalError:
+			return nil, &HiSLIPError{Fatal: msg.msgType == hislipFatalError, Code: msg.control, Message: string(msg.payload)}
+		}
+	}
+}
+
+func (ht *HiSLIPTransport) Close() error {
+
+	if ht.syncConn == nil {
+		return nil
+	}
+	err := ht.syncConn.Close()
+	asyncErr := ht.asyncConn.Close()
+	// The reader must be gone before the session is reopened
+	<-ht.asyncDone
+	ht.syncConn = nil
+	ht.asyncConn = nil
+	if err != nil {
+		return err
+	}
+	return asyncErr
+}
+
+// Device clear. It also negotiates overlapped/synchronized mode and resets message IDs.
+func (ht *HiSLIPTransport) Clear() error {
+
+	err := ht.recover()
+	if err != nil {
+		return err
+	}
+	_, err = ht.asyncRequest(hislipMessage{msgType: hislipAsyncDeviceClear}, hislipAsyncDeviceClearAcknowledge)
+	if err != nil {
+		return errors.Wrap(err, "HiSLIP device clear failed")
+	}
+	request := byte(0)
+	if ht.Overlapped {
+		request = 1
+	}
+	err = ht.deadline.apply(ht.Timeout, ht.syncConn.SetDeadline)
+	if err != nil {
+		return err
+	}
+	err = writeHislip(ht.syncConn, hislipMessage{msgType: hislipDeviceClearComplete, control: request})
+	if err != nil {
+		ht.broken = true
+		return err
+	}
+	for {
+		msg, err := readHislip(ht.syncConn)
+		if err != nil {
+			ht.broken = true
+			return errors.Wrap(err, "HiSLIP device clear failed")
+		}
+		// Pending responses are flushed by the server before the acknowledge
+		if msg.msgType == hislipDeviceClearAcknowledge {
+			ht.overlapped = msg.control&1 != 0
+			break
+		}
+	}
+	ht.messageID = hislipFirstMessageID
+	ht.lastSentID = hislipNoMessageID
+	ht.rmtDelivered = false
+	return nil
+}
+
+// Group execute trigger.
+func (ht *HiSLIPTransport) Trigger() error {
+
+	err := ht.recover()
+	if err != nil {
+		return err
+	}
+	err = ht.deadline.apply(ht.Timeout, ht.syncConn.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	msg := hislipMessage{msgType: hislipTrigger, param: ht.messageID}
+	if ht.rmtDelivered {
+		msg.control = 1
+		ht.rmtDelivered = false
+	}
+	err = writeHislip(ht.syncConn, msg)
+	if err != nil {
+		ht.broken = true
+		return err
+	}
+	ht.lastSentID = ht.messageID
+	ht.messageID += 2
+	return nil
+}
+
+// Read status byte (AsyncStatusQuery).
+func (ht *HiSLIPTransport) ReadSTB() (byte, error) {
+
+	msg := hislipMessage{msgType: hislipAsyncStatusQuery, param: ht.lastSentID}
+	if ht.rmtDelivered {
+		msg.control = 1
+	}
+	reply, err := ht.asyncRequest(msg, hislipAsyncStatusResponse)
+	if err != nil {
+		return 0, errors.Wrap(err, "HiSLIP status query failed")
+	}
+	// RMT-delivered is reported once
+	ht.rmtDelivered = false
+	return reply.control, nil
+}
+
+// Acquire exclusive lock, waiting up to timeout if it is held by another client.
+func (ht *HiSLIPTransport) Lock(timeout time.Duration) error {
+
+	msg := hislipMessage{msgType: hislipAsyncLock, control: 1, param: uint32(timeout / time.Millisecond)}
+	reply, err := ht.asyncRequestTimeout(msg, hislipAsyncLockResponse, ht.Timeout+timeout)
+	if err != nil {
+		return errors.Wrap(err, "HiSLIP lock failed")
+	}
+	if reply.control != 1 {
+		return fmt.Errorf("HiSLIP lock was not granted (response %d)", reply.control)
+	}
+	return nil
+}
+
+// Release lock.
+func (ht *HiSLIPTransport) Unlock() error {
+
+	msg := hislipMessage{msgType: hislipAsyncLock, control: 0, param: ht.lastSentID}
+	reply, err := ht.asyncRequest(msg, hislipAsyncLockResponse)
+	if err != nil {
+		return errors.Wrap(err, "HiSLIP unlock failed")
+	}
+	if reply.control != 1 {
+		return fmt.Errorf("HiSLIP lock was not released (response %d)", reply.control)
+	}
+	return nil
+}
+
+// Put instr in remote state.
+func (ht *HiSLIPTransport) Remote() error {
+	return ht.remoteLocal(hislipRemoteGotoRemote)
+}
+
+// Return instr to local state.
+func (ht *HiSLIPTransport) Local() error {
+	return ht.remoteLocal(hislipRemoteGotoLocal)
+}
+
+func (ht *HiSLIPTransport) remoteLocal(control byte) error {
+
+	msg := hislipMessage{msgType: hislipAsyncRemoteLocalControl, control: control, param: ht.lastSentID}
+	_, err := ht.asyncRequest(msg, hislipAsyncRemoteLocalResponse)
+	if err != nil {
+		return errors.Wrap(err, "HiSLIP remote/local control failed")
+	}
+	return nil
+}
+
+// Wait for service request (AsyncServiceRequest) and return its status byte.
+func (ht *HiSLIPTransport) WaitForSRQ(timeout time.Duration) (byte, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stb, err := ht.WaitForSRQContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 0, fmt.Errorf("no service request from \"%s\" within %s", ht.Host, timeout)
+	}
+	return stb, err
+}
+
+// WaitForSRQ until ctx is done.
+func (ht *HiSLIPTransport) WaitForSRQContext(ctx context.Context) (byte, error) {
+
+	if ht.asyncConn == nil {
+		return 0, fmt.Errorf("HiSLIP session to \"%s\" is not open", ht.Host)
+	}
+	select {
+	case stb := <-ht.serviceRequest:
+		return stb, nil
+	case <-ctx.Done():
+		return 0, errors.Wrapf(ctx.Err(), "no service request from \"%s\"", ht.Host)
+	}
+}
+
+// Bound synchronous channel I/O by t, I/O in progress is interrupted if t is in the past.
+func (ht *HiSLIPTransport) SetDeadline(t time.Time) error {
+
+	if ht.syncConn == nil {
+		return ht.deadline.setLimit(t, nil)
+	}
+	return ht.deadline.setLimit(t, ht.syncConn.SetDeadline)
+}
+
+// Check session is open. A synchronous channel interrupted in the middle of a message
+// can't be resynchronized, so the session is reopened.
+func (ht *HiSLIPTransport) recover() error {
+
+	if ht.syncConn == nil {
+		return fmt.Errorf("HiSLIP session to \"%s\" is not open", ht.Host)
+	}
+	if !ht.broken {
+		return nil
+	}
+	ht.Close()
+	err := ht.Open()
+	if err != nil {
+		return errors.Wrap(err, "couldn't reopen broken HiSLIP session")
+	}
+	return nil
+}
+
+func (ht *HiSLIPTransport) asyncRequest(msg hislipMessage, replyType byte) (hislipMessage, error) {
+	return ht.asyncRequestTimeout(msg, replyType, ht.Timeout)
+}
+
+func (ht *HiSLIPTransport) asyncRequestTimeout(msg hislipMessage, replyType byte, timeout time.Duration) (hislipMessage, error) {
+
+	if ht.asyncConn == nil {
+		return hislipMessage{}, fmt.Errorf("HiSLIP session to \"%s\" is not open", ht.Host)
+	}
+	ht.asyncMu.Lock()
+	defer ht.asyncMu.Unlock()
+
+	err := writeHislip(ht.asyncConn, msg)
+	if err != nil {
+		return hislipMessage{}, err
+	}
+	deadline := time.After(ht.deadline.remaining(timeout))
+	for {
+		select {
+		case reply, ok := <-ht.asyncReplies:
+			if !ok {
+				return hislipMessage{}, errors.Wrap(ht.asyncErr, "HiSLIP async channel is closed")
+			}
+			if reply.msgType == hislipError || reply.msgType == hislipFatalError {
+				return reply, &HiSLIPError{Fatal: reply.msgType == hislipFatalError, Code: reply.control, Message: string(reply.payload)}
+			}
+			if reply.msgType == replyType {
+				return reply, nil
+			}
+		case <-deadline:
+			return hislipMessage{}, fmt.Errorf("no HiSLIP async response from \"%s\" within %s", ht.Host, timeout)
+		}
+	}
+}
+
+// Dispatch async channel messages: service requests are queued, everything else is a reply.
+func (ht *HiSLIPTransport) readAsync(conn net.Conn, replies chan hislipMessage, serviceRequest chan byte, done chan struct{}) {
+
+	defer close(done)
+	defer close(replies)
+	for {
+		msg, err := readHislip(conn)
+		if err != nil {
+			ht.asyncErr = err
+			return
+		}
+		switch msg.msgType {
+		case hislipAsyncServiceRequest:
+			sendDroppingOldest(serviceRequest, msg.control)
+		case hislipAsyncInterrupted:
+		default:
+			sendDroppingOldest(replies, msg)
+		}
+	}
+}
+
+// Queue value without blocking the reader, the oldest value is dropped if nobody is waiting.
+func sendDroppingOldest[T any](ch chan T, value T) {
+	for {
+		select {
+		case ch <- value:
+			return
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+}
+
+// Read message of expected type during initialization.
+func (ht *HiSLIPTransport) expect(conn net.Conn, msgType byte) (hislipMessage, error) {
+
+	msg, err := readHislip(conn)
+	if err != nil {
+		return msg, err
+	}
+	if msg.msgType == hislipError || msg.msgType == hislipFatalError {
+		return msg, &HiSLIPError{Fatal: msg.msgType == hislipFatalError, Code: msg.control, Message: string(msg.payload)}
+	}
+	if msg.msgType != msgType {
+		return msg, fmt.Errorf("unexpected HiSLIP message type %d, expected %d", msg.msgType, msgType)
+	}
+	return msg, nil
+}
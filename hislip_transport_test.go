@@ -0,0 +1,287 @@
+package instruments
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hislipServer is a local HiSLIP stand-in with a single SCPI responder.
+type hislipServer struct {
+	mu        sync.Mutex
+	responses map[string]string
+	asyncConn net.Conn
+	locked    bool
+	remote    bool
+	triggered int
+	failClear bool   // drop sync channel instead of acknowledging device clear
+	closed    int    // async channels closed by client
+	rmt       []byte // RMT-delivered flags of status queries
+}
+
+func startHislipServer(t *testing.T, responses map[string]string) (*hislipServer, int) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv := &hislipServer{responses: responses}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv, ln.Addr().(*net.TCPAddr).Port
+}
+
+func (srv *hislipServer) serve(conn net.Conn) {
+
+	defer conn.Close()
+	msg, err := readHislip(conn)
+	if err != nil {
+		return
+	}
+	switch msg.msgType {
+	case hislipInitialize:
+		writeHislip(conn, hislipMessage{msgType: hislipInitializeResponse, param: hislipProtocolVersion<<16 | 7})
+		srv.serveSync(conn)
+	case hislipAsyncInitialize:
+		srv.mu.Lock()
+		srv.asyncConn = conn
+		srv.mu.Unlock()
+		writeHislip(conn, hislipMessage{msgType: hislipAsyncInitializeResponse, param: hislipVendorID})
+		srv.serveAsync(conn)
+	}
+}
+
+func (srv *hislipServer) serveSync(conn net.Conn) {
+
+	var data []byte
+	for {
+		msg, err := readHislip(conn)
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		switch msg.msgType {
+		case hislipData:
+			data = append(data, msg.payload...)
+		case hislipDataEnd:
+			cmd := string(append(data, msg.payload...))
+			data = nil
+			if response, ok := srv.responses[cmd]; ok {
+				writeHislip(conn, hislipMessage{msgType: hislipDataEnd, param: msg.param, payload: []byte(response + "\n")})
+			}
+			if cmd == "SRQ" {
+				writeHislip(srv.asyncConn, hislipMessage{msgType: hislipAsyncServiceRequest, control: 0x40})
+			}
+		case hislipTrigger:
+			srv.triggered++
+		case hislipDeviceClearComplete:
+			if srv.failClear {
+				srv.mu.Unlock()
+				return
+			}
+			writeHislip(conn, hislipMessage{msgType: hislipDeviceClearAcknowledge, control: msg.control & 1})
+		}
+		srv.mu.Unlock()
+	}
+}
+
+func (srv *hislipServer) serveAsync(conn net.Conn) {
+
+	for {
+		msg, err := readHislip(conn)
+		if err != nil {
+			srv.mu.Lock()
+			srv.closed++
+			srv.mu.Unlock()
+			return
+		}
+		srv.mu.Lock()
+		switch msg.msgType {
+		case hislipAsyncMaximumMessageSize:
+			// Small max message size to exercise chunked writes
+			writeHislip(conn, hislipMessage{
+				msgType: hislipAsyncMaximumMessageSizeResponse,
+				payload: binary.BigEndian.AppendUint64(nil, hislipHeaderSize+8),
+			})
+		case hislipAsyncDeviceClear:
+			writeHislip(conn, hislipMessage{msgType: hislipAsyncDeviceClearAcknowledge, control: 1})
+		case hislipAsyncStatusQuery:
+			srv.rmt = append(srv.rmt, msg.control)
+			writeHislip(conn, hislipMessage{msgType: hislipAsyncStatusResponse, control: 0x10})
+		case hislipAsyncLock:
+			srv.locked = msg.control == 1
+			writeHislip(conn, hislipMessage{msgType: hislipAsyncLockResponse, control: 1})
+		case hislipAsyncRemoteLocalControl:
+			srv.remote = msg.control == hislipRemoteGotoRemote
+			writeHislip(conn, hislipMessage{msgType: hislipAsyncRemoteLocalResponse})
+		}
+		srv.mu.Unlock()
+	}
+}
+
+func TestHiSLIPTransport(t *testing.T) {
+
+	srv, port := startHislipServer(t, map[string]string{
+		"*IDN?":     "Keysight Technologies,34980A,MY44001234,2.51-2.51-2.51-2.51",
+		"SYST:ERR?": "+0,\"No error\"",
+	})
+
+	transport := &HiSLIPTransport{Host: "127.0.0.1", Port: port, Timeout: time.Second, Overlapped: true}
+	session := Session{Transport: transport}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.SetErrorQuery("SYST:ERR?")
+
+	if !transport.overlapped {
+		t.Error("overlapped mode was not negotiated")
+	}
+	if session.GetInfo().Manufacturer != "Keysight Technologies" {
+		t.Errorf("unexpected manufacturer %q", session.GetInfo().Manufacturer)
+	}
+	err = session.Write("ROUT:OPEN:ALL ALL;" + strings.Repeat("*OPC;", 10) + "*OPC")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err = transport.Trigger(); err != nil {
+		t.Error(err)
+	}
+	if stb, err := transport.ReadSTB(); err != nil || stb != 0x10 {
+		t.Errorf("status query failed: %d, %v", stb, err)
+	}
+	if err = transport.Remote(); err != nil {
+		t.Error(err)
+	}
+	if err = transport.Lock(time.Second); err != nil {
+		t.Error(err)
+	}
+	if err = transport.Unlock(); err != nil {
+		t.Error(err)
+	}
+	if err = transport.Clear(); err != nil {
+		t.Error(err)
+	}
+	if transport.messageID != hislipFirstMessageID || transport.lastSentID != hislipNoMessageID {
+		t.Errorf("message IDs aren't reset by device clear: %#x, last sent %#x", transport.messageID, transport.lastSentID)
+	}
+
+	if err = session.WriteWithoutCheck("SRQ"); err != nil {
+		t.Fatal(err)
+	}
+	stb, err := transport.WaitForSRQ(time.Second)
+	if err != nil || stb != 0x40 {
+		t.Errorf("service request was not delivered: %d, %v", stb, err)
+	}
+	if _, err = transport.WaitForSRQ(10 * time.Millisecond); err == nil {
+		t.Error("unexpected second service request")
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.triggered != 1 || !srv.remote || srv.locked {
+		t.Errorf("unexpected server state: triggered %d, remote %t, locked %t", srv.triggered, srv.remote, srv.locked)
+	}
+}
+
+func TestHiSLIPTransportOpenFailure(t *testing.T) {
+
+	srv, port := startHislipServer(t, nil)
+	srv.failClear = true
+
+	// Overlapped mode isn't offered by the server, so Open negotiates it with device clear
+	transport := &HiSLIPTransport{Host: "127.0.0.1", Port: port, Timeout: time.Second, Overlapped: true}
+	err := transport.Open()
+	if err == nil {
+		t.Fatal("expected error when device clear fails")
+	}
+	if transport.syncConn != nil || transport.asyncConn != nil {
+		t.Error("channels must be closed after failed Open")
+	}
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		srv.mu.Lock()
+		closed := srv.closed
+		srv.mu.Unlock()
+		if closed == 1 {
+			return
+		}
+	}
+	t.Error("async channel is left open after failed Open")
+}
+
+func TestHiSLIPWaitForSRQCanceled(t *testing.T) {
+
+	_, port := startHislipServer(t, map[string]string{"*IDN?": "Keysight Technologies,34980A,MY44001234,2.41"})
+	session := Session{Transport: &HiSLIPTransport{Host: "127.0.0.1", Port: port, Timeout: time.Second}}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// No deadline, only cancellation ends the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := session.WaitForSRQContext(ctx)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("canceled wait for service request didn't return")
+	}
+}
+
+func TestHiSLIPTransportReopen(t *testing.T) {
+
+	srv, port := startHislipServer(t, map[string]string{"*IDN?": "Keysight Technologies,34980A,MY44001234,2.41"})
+	transport := &HiSLIPTransport{Host: "127.0.0.1", Port: port, Timeout: time.Second}
+	session := Session{Transport: transport}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// Broken session is reopened while the old async reader exits
+	for i := 0; i < 3; i++ {
+		transport.broken = true
+		response, err := session.Query("*IDN?")
+		if err != nil || !strings.HasPrefix(response, "Keysight") {
+			t.Fatalf("query after reopen failed: %q, %v", response, err)
+		}
+	}
+
+	// RMT-delivered is reported by the first status query only
+	for i := 0; i < 2; i++ {
+		if _, err = transport.ReadSTB(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.rmt) != 2 || srv.rmt[0] != 1 || srv.rmt[1] != 0 {
+		t.Errorf("unexpected RMT-delivered flags %v", srv.rmt)
+	}
+}
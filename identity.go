@@ -0,0 +1,63 @@
+package instruments
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Identity of instr parsed from *IDN? response "<manufacturer>,<model>,<serial>,<firmware>".
+type Identity struct {
+	Manufacturer string
+	Model        string
+	Serial       string
+	Firmware     string
+	// Firmware split into sub-revisions, e.g. main, boot and front panel revisions of
+	// "2.41-2.41-2.41-2.41"
+	FirmwareRevisions []string
+}
+
+// Parse *IDN? response. Fields are trimmed, serial and firmware may be missing ("0" is
+// reported by some instr without serial), commas after the third one belong to firmware.
+func ParseIdentity(response string) (Identity, error) {
+
+	var id Identity
+	fields := strings.SplitN(strings.TrimSpace(response), ",", 4)
+	for i := range fields {
+		fields[i] = strings.Trim(strings.TrimSpace(fields[i]), "\"")
+	}
+	if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+		return id, fmt.Errorf("invalid *IDN? response \"%s\": manufacturer and model are required", response)
+	}
+	id.Manufacturer = fields[0]
+	id.Model = fields[1]
+	if len(fields) > 2 {
+		id.Serial = fields[2]
+	}
+	if len(fields) > 3 {
+		id.Firmware = fields[3]
+		id.FirmwareRevisions = splitRevisions(id.Firmware)
+	}
+	return id, nil
+}
+
+// Split firmware into sub-revisions by "-" and "/", e.g. "C30 Mar 17 2006 09:29:10/A02 /K/J".
+func splitRevisions(firmware string) []string {
+
+	var revisions []string
+	for _, revision := range strings.FieldsFunc(firmware, func(r rune) bool { return r == '-' || r == '/' }) {
+		revision = strings.Join(strings.Fields(revision), " ")
+		if revision != "" {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions
+}
+
+func (id Identity) String() string {
+	return fmt.Sprintf(
+		"Manufacturer:\t%s\n"+
+			"Model:\t\t%s\n"+
+			"Serial:\t\t%s\n"+
+			"Firmware:\t%s\n",
+		id.Manufacturer, id.Model, id.Serial, id.Firmware)
+}
@@ -0,0 +1,70 @@
+package instruments
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIdentity(t *testing.T) {
+
+	tests := []struct {
+		response  string
+		expected  Identity
+		revisions []string
+	}{
+		{
+			"Agilent Technologies,34980A,MY44001234,2.41-2.41-2.41-2.41\n",
+			Identity{Manufacturer: "Agilent Technologies", Model: "34980A", Serial: "MY44001234", Firmware: "2.41-2.41-2.41-2.41"},
+			[]string{"2.41", "2.41", "2.41", "2.41"},
+		},
+		{
+			"KEITHLEY INSTRUMENTS INC., MODEL 2400, 1234567, C30   Mar 17 2006 09:29:10/A02  /K/J",
+			Identity{Manufacturer: "KEITHLEY INSTRUMENTS INC.", Model: "MODEL 2400", Serial: "1234567", Firmware: "C30   Mar 17 2006 09:29:10/A02  /K/J"},
+			[]string{"C30 Mar 17 2006 09:29:10", "A02", "K", "J"},
+		},
+		{
+			"Vendor,Box",
+			Identity{Manufacturer: "Vendor", Model: "Box"},
+			nil,
+		},
+		{
+			"Vendor,Box,0,1.0,extra",
+			Identity{Manufacturer: "Vendor", Model: "Box", Serial: "0", Firmware: "1.0,extra"},
+			[]string{"1.0,extra"},
+		},
+	}
+	for _, test := range tests {
+		id, err := ParseIdentity(test.response)
+		if err != nil {
+			t.Errorf("%q: %v", test.response, err)
+			continue
+		}
+		revisions := id.FirmwareRevisions
+		id.FirmwareRevisions = nil
+		if id.String() != test.expected.String() || strings.Join(revisions, "|") != strings.Join(test.revisions, "|") {
+			t.Errorf("%q: unexpected identity %#v, revisions %q", test.response, id, revisions)
+		}
+	}
+
+	for _, response := range []string{"", "  \n", "Vendor", ",Model", "Vendor,"} {
+		_, err := ParseIdentity(response)
+		if err == nil {
+			t.Errorf("%q: expected error", response)
+		}
+	}
+}
+
+func TestSessionIdentity(t *testing.T) {
+
+	session := Session{Transport: NewKeithley2400Simulator(OpenCircuit{})}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := session.GetInfo(); !strings.Contains(id.Model, "2400") {
+		t.Errorf("unexpected identity %#v", id)
+	}
+	if !strings.HasPrefix(session.String(), "Manufacturer:\tKEITHLEY") {
+		t.Errorf("unexpected string %q", session.String())
+	}
+}
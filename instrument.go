@@ -0,0 +1,30 @@
+package instruments
+
+import "context"
+
+// Instrument is the SCPI session the drivers talk to. Session implements it on top of
+// any Transport (VisaObjectWrapper uses a native VISA library, build tag "visa"), other backends and test
+// doubles can be plugged into the drivers in the same way.
+type Instrument interface {
+	// Write command to instr and check instr errors
+	Write(cmd string) error
+	// Write command to instr without instr error check
+	WriteWithoutCheck(cmd string) error
+	// Write command to instr and read response
+	Query(cmd string) (string, error)
+	// Read raw response bytes from instr
+	ReadBytes() ([]byte, error)
+	// Check instrument errors
+	CheckErrors() error
+	// Set query used by CheckErrors (e.g. "SYST:ERR?")
+	SetErrorQuery(query string)
+	// Close the session
+	Close() error
+}
+
+// Locker is implemented by instruments able to run multi-command transactions exclusively,
+// calls made with the context returned by Lock belong to the transaction.
+type Locker interface {
+	Lock(ctx context.Context) (context.Context, error)
+	Unlock(ctx context.Context)
+}
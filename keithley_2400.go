@@ -4,309 +4,817 @@
 package instruments
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// Keithley 2400 status word bits (:READ? STAT element)
+const (
+	Status2400Overflow          = 1 << 0
+	Status2400Filter            = 1 << 1
+	Status2400FrontTerminals    = 1 << 2
+	Status2400Compliance        = 1 << 3
+	Status2400OVP               = 1 << 4
+	Status2400Math              = 1 << 5
+	Status2400Null              = 1 << 6
+	Status2400Limits            = 1 << 7
+	Status2400AutoOhms          = 1 << 10
+	Status2400VoltageMeasure    = 1 << 11
+	Status2400CurrentMeasure    = 1 << 12
+	Status2400ResistanceMeasure = 1 << 13
+	Status2400VoltageSource     = 1 << 14
+	Status2400CurrentSource     = 1 << 15
+	Status2400RangeCompliance   = 1 << 16
+	Status2400OffsetComp        = 1 << 17
+	Status2400ContactCheck      = 1 << 18
+	Status2400RemoteSense       = 1 << 22
+	Status2400PulseMode         = 1 << 23
+)
+
+var status2400Names = []string{
+	"OFLO", "FILT", "FRONT", "CMPL", "OVP", "MATH", "NULL", "LIMITS", "bit8", "bit9", "AUTO_OHMS", "V_MEAS",
+	"I_MEAS", "OHM_MEAS", "V_SOUR", "I_SOUR", "RANGE_CMPL", "OFFSET", "CONTACT", "bit19", "bit20", "bit21",
+	"REMOTE", "PULSE",
+}
+
+// Слово состояния Keithley 2400 (элемент STAT ответа :READ?), биты Status2400*.
+type Status2400 uint32
+
+// Измеряемая величина достигла ограничения (SENS:*:PROT).
+func (status Status2400) Compliance() bool {
+	return status&Status2400Compliance != 0
+}
+
+// Выход ограничен защитой от перенапряжения (SOUR:VOLT:PROT).
+func (status Status2400) OverVoltage() bool {
+	return status&Status2400OVP != 0
+}
+
+// Ограничение достигнуто по пределу диапазона измерения, а не по заданному значению.
+func (status Status2400) RangeCompliance() bool {
+	return status&Status2400RangeCompliance != 0
+}
+
+// Переполнение при измерении.
+func (status Status2400) Overflow() bool {
+	return status&Status2400Overflow != 0
+}
+
+// Отсчёт получен с включённым фильтром.
+func (status Status2400) Filter() bool {
+	return status&Status2400Filter != 0
+}
+
+// Установленные биты, например "CMPL|V_MEAS|V_SOUR".
+func (status Status2400) String() string {
+	return bitNames(uint32(status), status2400Names)
+}
+
+// Элементы данных ответа :READ? (FORM:ELEM), прибор выводит их всегда в порядке VOLT,CURR,RES,TIME,STAT.
+type ReadingElements uint8
+
+const (
+	ElementVoltage ReadingElements = 1 << iota
+	ElementCurrent
+	ElementResistance
+	ElementTime
+	ElementStatus
+
+	// Все элементы, устанавливаются при инициализации
+	ElementsAll = ElementVoltage | ElementCurrent | ElementResistance | ElementTime | ElementStatus
+)
+
+var readingElementNames = []string{"VOLT", "CURR", "RES", "TIME", "STAT"}
+
+// Параметр FORM:ELEM, например "VOLT,CURR".
+func (elements ReadingElements) String() string {
+
+	var names []string
+	for i, name := range readingElementNames {
+		if elements&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Отсчёт источника-измерителя, значения отсутствующих в Elements элементов нулевые.
+type Reading struct {
+	Voltage    float64
+	Current    float64
+	Resistance float64       // 9.91e37, если сопротивление не измеряется
+	Time       time.Duration // отметка времени отсчёта
+	Status     Status2400
+	Elements   ReadingElements
+	Compliance bool // измеряемая величина достигла ограничения SENS:*:PROT
+}
+
+// ComplianceError возвращается в режиме SetComplianceError, если отсчёт получен в ограничении.
+type ComplianceError struct {
+	Function string  // ограниченная величина, "CURR" или "VOLT"
+	Limit    float64 // значение ограничения SENS:*:PROT
+	Index    int     // номер отсчёта
+	Reading  Reading
+}
+
+func (e *ComplianceError) Error() string {
+
+	name := "current"
+	if e.Function == "VOLT" {
+		name = "voltage"
+	}
+	return fmt.Sprintf("reading %d is in %s compliance (limit %g)", e.Index, name, e.Limit)
+}
+
 type Keithley2400 struct {
-	instr         *VisaObjectWrapper
+	instr         Instrument
 	voltageRanges []float64
 	currentRanges []float64
+	elements      ReadingElements
+	source        SourceFunction
+	strict        bool          // отсчёт в ограничении возвращает ComplianceError
+	offMode       OutputOffMode // состояние выключенного выхода, задаётся при настройке источника
+}
+
+// Регистрация драйвера для OpenInstrument.
+func init() {
+	RegisterDriver(DriverInfo{
+		Name:         "Keithley 2400",
+		Manufacturer: regexp.MustCompile(`(?i)^keithley`),
+		Model:        regexp.MustCompile(`(?i)^(model )?24(00|01|10|20|25|30|40)$`),
+		New: func(instr Instrument) (Driver, error) {
+			ke2400 := &Keithley2400{}
+			err := ke2400.Init(instr)
+			if err != nil {
+				return nil, err
+			}
+			return ke2400, nil
+		},
+	})
 }
 
 // Инициализация источника-измерителя.
-func (ke2400 *Keithley2400) Init(instr *VisaObjectWrapper) error {
+func (ke2400 *Keithley2400) Init(instr Instrument) error {
+	return ke2400.InitContext(context.Background(), instr)
+}
+
+// Инициализация источника-измерителя с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) InitContext(ctx context.Context, instr Instrument) error {
 
 	ke2400.instr = instr
-	ke2400.instr.SetErrorQuery("SYST:ERR?")
-	err := ke2400.instr.Write("*RST")
+	ctx, unlock, err := lockInstr(ctx, instr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	instr = bindContext(ctx, instr)
+	instr.SetErrorQuery("SYST:ERR?")
+	err = instr.Write("*RST")
+	if err != nil {
+		return err
+	}
+	err = instr.Write("FORM:ELEM " + ElementsAll.String())
 	if err != nil {
 		return err
 	}
+	ke2400.elements = ElementsAll
+	ke2400.source = SourceVoltage
+	ke2400.offMode = OutputOffZero
 	ke2400.voltageRanges = []float64{0.02, 0.2, 2, 20, 200}
 	ke2400.currentRanges = []float64{10e-9, 100e-9, 1e-6, 10e-6, 100e-6, 1e-3, 0.01, 0.1, 1}
 	return nil
 }
 
-// Считать значения тока и напряжения.
-func (ke2400 *Keithley2400) ReadSrcData() (current float64, voltage float64, err error) {
+// Задать элементы данных ответа :READ?.
+func (ke2400 *Keithley2400) SetReadingElements(elements ReadingElements) error {
+	return ke2400.SetReadingElementsContext(context.Background(), elements)
+}
 
-	response, err := ke2400.instr.Query(":READ?")
+// SetReadingElements с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SetReadingElementsContext(ctx context.Context, elements ReadingElements) error {
+
+	if elements == 0 || elements&^ElementsAll != 0 {
+		return fmt.Errorf("invalid reading elements %#x", uint8(elements))
+	}
+	instr := bindContext(ctx, ke2400.instr)
+	err := instr.Write("FORM:ELEM " + elements.String())
+	if err != nil {
+		return errors.Wrap(err, "reading elements setting fail")
+	}
+	ke2400.elements = elements
+	return nil
+}
+
+// Включить или выключить режим, в котором отсчёт в ограничении возвращает ComplianceError
+// вместе со считанными отсчётами.
+func (ke2400 *Keithley2400) SetComplianceError(enabled bool) {
+	ke2400.strict = enabled
+}
+
+// Считать отсчёты: один или по одному на каждый триггер, если TRIG:COUN больше 1.
+func (ke2400 *Keithley2400) ReadReadings() ([]Reading, error) {
+	return ke2400.ReadReadingsContext(context.Background())
+}
+
+// ReadReadings с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) ReadReadingsContext(ctx context.Context) ([]Reading, error) {
+
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "data read fail")
+		return nil, err
 	}
-	splitResponse := strings.Split(response, ",")
-	current, err = strconv.ParseFloat(splitResponse[2], 64)
+	defer unlock()
+
+	instr := bindContext(ctx, ke2400.instr)
+	response, err := instr.Query(":READ?")
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "conversion for current value failed")
+		return nil, errors.Wrap(err, "data read fail")
 	}
-	voltage, err = strconv.ParseFloat(splitResponse[1], 64)
+	readings, err := parseReadings(response, ke2400.elements)
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "conversion for voltage value failed")
+		return nil, err
 	}
-	return
+	return readings, ke2400.checkCompliance(instr, readings)
+}
+
+// Отметить отсчёты в ограничении по слову состояния, а без элемента STAT по SENS:*:PROT:TRIP?,
+// который относится только к последнему отсчёту. В режиме SetComplianceError вернуть ComplianceError.
+func (ke2400 *Keithley2400) checkCompliance(instr Instrument, readings []Reading) error {
+
+	if len(readings) == 0 {
+		return nil
+	}
+	sense := ke2400.source.sense()
+	if ke2400.elements&ElementStatus != 0 {
+		for i := range readings {
+			readings[i].Compliance = readings[i].Status.Compliance()
+		}
+	} else {
+		response, err := instr.Query(fmt.Sprintf("SENS:%s:PROT:TRIP?", sense))
+		if err != nil {
+			return errors.Wrap(err, "compliance state read fail")
+		}
+		readings[len(readings)-1].Compliance = strings.TrimSpace(response) == "1"
+	}
+	if !ke2400.strict {
+		return nil
+	}
+	for i := range readings {
+		if !readings[i].Compliance {
+			continue
+		}
+		response, err := instr.Query(fmt.Sprintf("SENS:%s:PROT?", sense))
+		if err != nil {
+			return errors.Wrap(err, "compliance limit read fail")
+		}
+		limit, err := strconv.ParseFloat(strings.TrimSpace(response), 64)
+		if err != nil {
+			return errors.Wrap(err, "conversion for compliance limit failed")
+		}
+		return &ComplianceError{Function: sense, Limit: limit, Index: i, Reading: readings[i]}
+	}
+	return nil
+}
+
+// Считать значения тока и напряжения первого отсчёта, в режиме SetComplianceError они
+// возвращаются и вместе с ComplianceError.
+func (ke2400 *Keithley2400) ReadSrcData() (current float64, voltage float64, err error) {
+	return ke2400.ReadSrcDataContext(context.Background())
+}
+
+// Считать значения тока и напряжения с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) ReadSrcDataContext(ctx context.Context) (current float64, voltage float64, err error) {
+
+	if ke2400.elements&(ElementVoltage|ElementCurrent) != ElementVoltage|ElementCurrent {
+		return 0, 0, fmt.Errorf("reading elements %s don't include voltage and current", ke2400.elements)
+	}
+	readings, err := ke2400.ReadReadingsContext(ctx)
+	if len(readings) == 0 {
+		return 0, 0, err
+	}
+	return readings[0].Current, readings[0].Voltage, err
+}
+
+// Разобрать ответ :READ? из одного или нескольких отсчётов с элементами elements.
+func parseReadings(response string, elements ReadingElements) ([]Reading, error) {
+
+	var order []ReadingElements
+	for element := ElementVoltage; element <= ElementStatus; element <<= 1 {
+		if elements&element != 0 {
+			order = append(order, element)
+		}
+	}
+	fields := strings.Split(strings.TrimSpace(response), ",")
+	if len(order) == 0 || len(fields)%len(order) != 0 {
+		return nil, fmt.Errorf("%d fields of reading data don't match elements %s", len(fields), elements)
+	}
+	readings := make([]Reading, len(fields)/len(order))
+	for i := range fields {
+		value, err := strconv.ParseFloat(strings.TrimSpace(fields[i]), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "conversion of reading field %d failed", i)
+		}
+		reading := &readings[i/len(order)]
+		reading.Elements = elements
+		switch order[i%len(order)] {
+		case ElementVoltage:
+			reading.Voltage = value
+		case ElementCurrent:
+			reading.Current = value
+		case ElementResistance:
+			reading.Resistance = value
+		case ElementTime:
+			reading.Time = time.Duration(value * float64(time.Second))
+		case ElementStatus:
+			reading.Status = Status2400(value)
+		}
+	}
+	return readings, nil
 }
 
 // Сконфигурировать выход источника-измерителя как источник напряжения с автодиапазоном.
 func (ke2400 *Keithley2400) SetAutoRangeVoltageSource(srcVoltage, limCurrent, nplc float64, remote bool) error {
+	return ke2400.SetAutoRangeVoltageSourceContext(context.Background(), srcVoltage, limCurrent, nplc, remote)
+}
 
-	var err error
+// SetAutoRangeVoltageSource с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SetAutoRangeVoltageSourceContext(ctx context.Context, srcVoltage, limCurrent, nplc float64, remote bool) error {
+
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	instr := bindContext(ctx, ke2400.instr)
 	errContext := "auto range voltage source init fail"
 
-	err = ke2400.instr.WriteWithoutCheck("SOUR:FUNC VOLT")
+	err = instr.WriteWithoutCheck("SOUR:FUNC VOLT")
+	if err != nil {
+		return errors.Wrap(err, errContext)
+	}
+	ke2400.source = SourceVoltage
+	err = instr.WriteWithoutCheck("OUTP:SMOD " + string(ke2400.offMode))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("OUTP:SMOD ZERO")
+	err = instr.WriteWithoutCheck("SOUR:VOLT:MODE FIX")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:VOLT:MODE AUTO")
+	err = instr.WriteWithoutCheck("SOUR:VOLT:RANG:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
+	err = instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
+	err = instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:FUNC \"CURR:DC\"")
+	err = instr.WriteWithoutCheck("SENS:FUNC \"CURR:DC\"")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:CURR:DC:RANG:AUTO ON")
+	err = instr.WriteWithoutCheck("SENS:CURR:DC:RANG:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	// Error checkable settings
-	err = ke2400.instr.Write(fmt.Sprintf("SOUR:VOLT %f", srcVoltage))
+	err = instr.Write(fmt.Sprintf("SOUR:VOLT %f", srcVoltage))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:CURR:PROT %f", limCurrent))
+	err = instr.Write(fmt.Sprintf("SENS:CURR:PROT %f", limCurrent))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:CURR:NPLC %f", nplc))
+	err = instr.Write(fmt.Sprintf("SENS:CURR:NPLC %f", nplc))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write("SOUR:VOLT:PROT:LEV 210")
+	err = instr.Write("SOUR:VOLT:PROT:LEV 210")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	if remote {
-		ke2400.instr.WriteWithoutCheck(":SYST:RSEN ON")
+		instr.WriteWithoutCheck(":SYST:RSEN ON")
 	} else {
-		ke2400.instr.WriteWithoutCheck(":SYST:RSEN OFF")
+		instr.WriteWithoutCheck(":SYST:RSEN OFF")
 	}
 	return nil
 }
 
 // Сконфигурировать выход источника-измерителя как источник напряжения с фиксированным диапазоном.
 func (ke2400 *Keithley2400) SetFixedRangeVoltageSource(srcVoltage, limCurrent, nplc float64, remote bool) error {
+	return ke2400.SetFixedRangeVoltageSourceContext(context.Background(), srcVoltage, limCurrent, nplc, remote)
+}
+
+// SetFixedRangeVoltageSource с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SetFixedRangeVoltageSourceContext(ctx context.Context, srcVoltage, limCurrent, nplc float64, remote bool) error {
+
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	var err error
+	instr := bindContext(ctx, ke2400.instr)
 	errContext := "fixed range voltage source init fail"
 	vltRng := ke2400.GetSuitableVoltageRange(srcVoltage)
 	curRng := ke2400.GetSuitableCurrentRange(limCurrent)
 
-	err = ke2400.instr.WriteWithoutCheck("SOUR:FUNC VOLT")
+	err = instr.WriteWithoutCheck("SOUR:FUNC VOLT")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("OUTP:SMOD ZERO")
+	ke2400.source = SourceVoltage
+	err = instr.WriteWithoutCheck("OUTP:SMOD " + string(ke2400.offMode))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:VOLT:MODE FIX")
+	err = instr.WriteWithoutCheck("SOUR:VOLT:MODE FIX")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
+	err = instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
+	err = instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:FUNC \"CURR:DC\"")
+	err = instr.WriteWithoutCheck("SENS:FUNC \"CURR:DC\"")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:CURR:DC:RANG:AUTO OFF")
+	err = instr.WriteWithoutCheck("SENS:CURR:DC:RANG:AUTO OFF")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	// Error checkable settings
-	err = ke2400.instr.Write(fmt.Sprintf("SOUR:VOLT:RANG %f", vltRng))
+	err = instr.Write(fmt.Sprintf("SOUR:VOLT:RANG %f", vltRng))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SOUR:VOLT %f", srcVoltage))
+	err = instr.Write(fmt.Sprintf("SOUR:VOLT %f", srcVoltage))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:CURR:DC:RANG %f", curRng))
+	err = instr.Write(fmt.Sprintf("SENS:CURR:DC:RANG %f", curRng))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:CURR:PROT %f", limCurrent))
+	err = instr.Write(fmt.Sprintf("SENS:CURR:PROT %f", limCurrent))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:CURR:NPLC %f", nplc))
+	err = instr.Write(fmt.Sprintf("SENS:CURR:NPLC %f", nplc))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write("SOUR:VOLT:PROT:LEV 210")
+	err = instr.Write("SOUR:VOLT:PROT:LEV 210")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	if remote {
-		ke2400.instr.WriteWithoutCheck("SYST:RSEN ON")
+		instr.WriteWithoutCheck("SYST:RSEN ON")
 	} else {
-		ke2400.instr.WriteWithoutCheck("SYST:RSEN OFF")
+		instr.WriteWithoutCheck("SYST:RSEN OFF")
 	}
 	return nil
 }
 
 // Сконфигурировать выход источника-измерителя как источник тока с автодиапазоном
 func (ke2400 *Keithley2400) SetAutoRangeCurrentSource(srcCurrent, limVoltage, nplc float64, remote bool) error {
+	return ke2400.SetAutoRangeCurrentSourceContext(context.Background(), srcCurrent, limVoltage, nplc, remote)
+}
+
+// SetAutoRangeCurrentSource с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SetAutoRangeCurrentSourceContext(ctx context.Context, srcCurrent, limVoltage, nplc float64, remote bool) error {
+
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	var err error
+	instr := bindContext(ctx, ke2400.instr)
 	errContext := "auto range current source init fail"
 
-	err = ke2400.instr.WriteWithoutCheck("SOUR:FUNC CURR")
+	err = instr.WriteWithoutCheck("SOUR:FUNC CURR")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("OUTP:SMOD ZERO")
+	ke2400.source = SourceCurrent
+	err = instr.WriteWithoutCheck("OUTP:SMOD " + string(ke2400.offMode))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:CURR:MODE AUTO")
+	err = instr.WriteWithoutCheck("SOUR:CURR:MODE FIX")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
+	err = instr.WriteWithoutCheck("SOUR:CURR:RANG:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
+	err = instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:FUNC \"VOLT:DC\"")
+	err = instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:VOLT:DC:RANG:AUTO ON")
+	err = instr.WriteWithoutCheck("SENS:FUNC \"VOLT:DC\"")
+	if err != nil {
+		return errors.Wrap(err, errContext)
+	}
+	err = instr.WriteWithoutCheck("SENS:VOLT:DC:RANG:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	// Error checkable settings
-	err = ke2400.instr.Write(fmt.Sprintf("SOUR:CURR %f", srcCurrent))
+	err = instr.Write(fmt.Sprintf("SOUR:CURR %f", srcCurrent))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:VOLT:PROT %f", limVoltage))
+	err = instr.Write(fmt.Sprintf("SENS:VOLT:PROT %f", limVoltage))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:VOLT:NPLC %f", nplc))
+	err = instr.Write(fmt.Sprintf("SENS:VOLT:NPLC %f", nplc))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	if remote {
-		ke2400.instr.WriteWithoutCheck("SYST:RSEN ON")
+		instr.WriteWithoutCheck("SYST:RSEN ON")
 	} else {
-		ke2400.instr.WriteWithoutCheck("SYST:RSEN OFF")
+		instr.WriteWithoutCheck("SYST:RSEN OFF")
 	}
 	return nil
 }
 
 // Сконфигурировать выход источника-измерителя как источник тока с фиксированным диапазоном.
 func (ke2400 *Keithley2400) SetFixedRangeCurrentSource(srcCurrent, limVoltage, nplc float64, remote bool) error {
+	return ke2400.SetFixedRangeCurrentSourceContext(context.Background(), srcCurrent, limVoltage, nplc, remote)
+}
+
+// SetFixedRangeCurrentSource с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SetFixedRangeCurrentSourceContext(ctx context.Context, srcCurrent, limVoltage, nplc float64, remote bool) error {
 
-	var err error
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	instr := bindContext(ctx, ke2400.instr)
 	errContext := "fixed range current source init fail"
 	curRng := ke2400.GetSuitableCurrentRange(srcCurrent)
 	vltRng := ke2400.GetSuitableVoltageRange(limVoltage)
 
-	err = ke2400.instr.WriteWithoutCheck("SOUR:FUNC CURR")
+	err = instr.WriteWithoutCheck("SOUR:FUNC CURR")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("OUTP:SMOD ZERO")
+	ke2400.source = SourceCurrent
+	err = instr.WriteWithoutCheck("OUTP:SMOD " + string(ke2400.offMode))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:CURR:MODE FIX")
+	err = instr.WriteWithoutCheck("SOUR:CURR:MODE FIX")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
+	err = instr.WriteWithoutCheck("SOUR:DEL:AUTO ON")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
+	err = instr.WriteWithoutCheck("SYST:AZER:STAT ONCE")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:FUNC \"VOLT:DC\"")
+	err = instr.WriteWithoutCheck("SENS:FUNC \"VOLT:DC\"")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.WriteWithoutCheck("SENS:VOLT:DC:RANG:AUTO OFF")
+	err = instr.WriteWithoutCheck("SENS:VOLT:DC:RANG:AUTO OFF")
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	// Error-checkable settings
-	err = ke2400.instr.Write(fmt.Sprintf("SOUR:CURR:RANG %f", curRng))
+	err = instr.Write(fmt.Sprintf("SOUR:CURR:RANG %f", curRng))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SOUR:CURR %f", srcCurrent))
+	err = instr.Write(fmt.Sprintf("SOUR:CURR %f", srcCurrent))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:VOLT:DC:RANG %f", vltRng))
+	err = instr.Write(fmt.Sprintf("SENS:VOLT:DC:RANG %f", vltRng))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:VOLT:PROT %f", limVoltage))
+	err = instr.Write(fmt.Sprintf("SENS:VOLT:PROT %f", limVoltage))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
-	err = ke2400.instr.Write(fmt.Sprintf("SENS:VOLT:NPLC %f", nplc))
+	err = instr.Write(fmt.Sprintf("SENS:VOLT:NPLC %f", nplc))
 	if err != nil {
 		return errors.Wrap(err, errContext)
 	}
 
 	if remote {
-		ke2400.instr.WriteWithoutCheck("SYST:RSEN ON")
+		instr.WriteWithoutCheck("SYST:RSEN ON")
 	} else {
-		ke2400.instr.WriteWithoutCheck("SYST:RSEN OFF")
+		instr.WriteWithoutCheck("SYST:RSEN OFF")
+	}
+	return nil
+}
+
+// Период ступеней RampTo.
+const ke2400RampInterval = 50 * time.Millisecond
+
+// Состояние выключенного выхода (OUTP:SMOD).
+type OutputOffMode string
+
+const (
+	OutputOffNormal        OutputOffMode = "NORM" // источник 0 В, ограничение тока 0.5% диапазона
+	OutputOffZero          OutputOffMode = "ZERO" // источник 0 В (0 А) с заданным ограничением
+	OutputOffHighImpedance OutputOffMode = "HIMP" // выходное реле разомкнуто
+	OutputOffGuard         OutputOffMode = "GUAR" // источник тока 0 А
+)
+
+// Включить или выключить выход источника-измерителя.
+func (ke2400 *Keithley2400) SetOutput(on bool) error {
+	return ke2400.SetOutputContext(context.Background(), on)
+}
+
+// SetOutput с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SetOutputContext(ctx context.Context, on bool) error {
+
+	cmd := "OUTP OFF"
+	if on {
+		cmd = "OUTP ON"
+	}
+	instr := bindContext(ctx, ke2400.instr)
+	err := instr.Write(cmd)
+	if err != nil {
+		return errors.Wrap(err, "output switching fail")
+	}
+	return nil
+}
+
+// Считать состояние выхода источника-измерителя.
+func (ke2400 *Keithley2400) GetOutput() (bool, error) {
+	return ke2400.GetOutputContext(context.Background())
+}
+
+// GetOutput с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) GetOutputContext(ctx context.Context) (bool, error) {
+
+	instr := bindContext(ctx, ke2400.instr)
+	response, err := instr.Query("OUTP?")
+	if err != nil {
+		return false, errors.Wrap(err, "output state read fail")
+	}
+	return strings.TrimSpace(response) == "1", nil
+}
+
+// Задать состояние выключенного выхода, оно сохраняется при последующей настройке источника.
+func (ke2400 *Keithley2400) SetOutputOffMode(mode OutputOffMode) error {
+	return ke2400.SetOutputOffModeContext(context.Background(), mode)
+}
+
+// SetOutputOffMode с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SetOutputOffModeContext(ctx context.Context, mode OutputOffMode) error {
+
+	switch mode {
+	case OutputOffNormal, OutputOffZero, OutputOffHighImpedance, OutputOffGuard:
+	default:
+		return fmt.Errorf("unknown output off mode \"%s\"", mode)
+	}
+	instr := bindContext(ctx, ke2400.instr)
+	err := instr.Write("OUTP:SMOD " + string(mode))
+	if err != nil {
+		return errors.Wrap(err, "output off mode setting fail")
 	}
+	ke2400.offMode = mode
 	return nil
 }
 
+// Плавно перевести уровень источника в level со скоростью slewRate (В/с для источника напряжения,
+// А/с для источника тока) ступенями через ke2400RampInterval. Если выход выключен, уровень
+// устанавливается сразу. При ошибке или отмене через ctx остаётся последний установленный уровень.
+func (ke2400 *Keithley2400) RampTo(level, slewRate float64) error {
+	return ke2400.RampToContext(context.Background(), level, slewRate)
+}
+
+// RampTo с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) RampToContext(ctx context.Context, level, slewRate float64) error {
+
+	errContext := "source ramp fail"
+	if !(slewRate > 0) || math.IsInf(slewRate, 1) {
+		return errors.Wrap(fmt.Errorf("invalid slew rate %g", slewRate), errContext)
+	}
+	if math.IsNaN(level) || math.IsInf(level, 0) {
+		return errors.Wrap(fmt.Errorf("invalid source level %g", level), errContext)
+	}
+
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	instr := bindContext(ctx, ke2400.instr)
+	function := ke2400.source
+	response, err := instr.Query("OUTP?")
+	if err != nil {
+		return errors.Wrap(err, errContext)
+	}
+	if strings.TrimSpace(response) != "1" {
+		err = instr.Write(fmt.Sprintf("SOUR:%s %g", function, level))
+		if err != nil {
+			return errors.Wrap(err, errContext)
+		}
+		return nil
+	}
+
+	response, err = instr.Query(fmt.Sprintf("SOUR:%s?", function))
+	if err != nil {
+		return errors.Wrap(err, errContext)
+	}
+	start, err := strconv.ParseFloat(strings.TrimSpace(response), 64)
+	if err != nil {
+		return errors.Wrap(errors.Wrap(err, "conversion for source level failed"), errContext)
+	}
+	if math.IsNaN(start) || math.IsInf(start, 0) {
+		return errors.Wrap(fmt.Errorf("invalid source level %g read back", start), errContext)
+	}
+	steps := int(math.Ceil(math.Abs(level-start) / (slewRate * ke2400RampInterval.Seconds())))
+	steps = max(steps, 1)
+	for i := 1; i <= steps; i++ {
+		if i > 1 {
+			err = sleepContext(ctx, ke2400RampInterval)
+			if err != nil {
+				return errors.Wrap(err, errContext)
+			}
+		}
+		value := level
+		if i < steps {
+			value = start + (level-start)*float64(i)/float64(steps)
+		}
+		err = instr.Write(fmt.Sprintf("SOUR:%s %g", function, value))
+		if err != nil {
+			return errors.Wrapf(err, "%s: step %d of %d", errContext, i, steps)
+		}
+	}
+	return nil
+}
+
+// Дождаться завершения операций источника-измерителя, не дольше timeout.
+func (ke2400 *Keithley2400) WaitForOPC(timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return ke2400.WaitForOPCContext(ctx)
+}
+
+// WaitForOPC с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) WaitForOPCContext(ctx context.Context) error {
+	return waitForOPC(ctx, ke2400.instr)
+}
+
+// Закрыть сессию источника-измерителя.
+func (ke2400 *Keithley2400) Close() error {
+
+	if ke2400.instr == nil {
+		return nil
+	}
+	return ke2400.instr.Close()
+}
+
 // Подобрать ближайший допустимый диапазон источника-измерителя для текущего значения напряжения.
 func (ke2400 *Keithley2400) GetSuitableVoltageRange(targetVoltage float64) float64 {
 	return getSuitableRange(ke2400.voltageRanges, targetVoltage)
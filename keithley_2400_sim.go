@@ -0,0 +1,683 @@
+package instruments
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DUT is a two-terminal device connected to a simulated source-measure unit.
+type DUT interface {
+	// Current through DUT at voltage across it
+	Current(voltage float64) float64
+	// Voltage across DUT at current through it
+	Voltage(current float64) float64
+}
+
+type Resistor struct {
+	Resistance float64
+}
+
+func (r Resistor) Current(voltage float64) float64 { return voltage / r.Resistance }
+func (r Resistor) Voltage(current float64) float64 { return current * r.Resistance }
+
+// Diode follows the Shockley equation I = Is*(exp(V/(N*Vt))-1).
+type Diode struct {
+	SaturationCurrent float64 // Is, 1e-12 A if zero
+	Ideality          float64 // N, 1 if zero
+	ThermalVoltage    float64 // Vt, 25.85 mV if zero
+}
+
+func (d Diode) params() (is, nvt float64) {
+
+	is, n, vt := d.SaturationCurrent, d.Ideality, d.ThermalVoltage
+	if is == 0 {
+		is = 1e-12
+	}
+	if n == 0 {
+		n = 1
+	}
+	if vt == 0 {
+		vt = 0.02585
+	}
+	return is, n * vt
+}
+
+func (d Diode) Current(voltage float64) float64 {
+	is, nvt := d.params()
+	return is * math.Expm1(voltage/nvt)
+}
+
+func (d Diode) Voltage(current float64) float64 {
+	is, nvt := d.params()
+	if current <= -is {
+		return math.Inf(-1)
+	}
+	return nvt * math.Log1p(current/is)
+}
+
+type OpenCircuit struct{}
+
+func (OpenCircuit) Current(voltage float64) float64 { return 0 }
+func (OpenCircuit) Voltage(current float64) float64 {
+	if current == 0 {
+		return 0
+	}
+	return math.Copysign(math.Inf(1), current)
+}
+
+type ShortCircuit struct{}
+
+func (ShortCircuit) Current(voltage float64) float64 {
+	if voltage == 0 {
+		return 0
+	}
+	return math.Copysign(math.Inf(1), voltage)
+}
+func (ShortCircuit) Voltage(current float64) float64 { return 0 }
+
+const (
+	ke2400MaxVoltage   = 210
+	ke2400MaxCurrent   = 1.05
+	ke2400NotANumber   = 9.91e37
+	ke2400Overflow     = 9.9e37
+	ke2400LineFreq     = 50
+	ke2400OutputOff    = 803
+	ke2400ReadOverhead = 0.002 // s per reading besides integration time
+	ke2400AutoDelay    = 0.001 // s, auto source delay
+)
+
+var (
+	ke2400SimVoltageRanges = []float64{0.2, 2, 20, 200}
+	ke2400SimCurrentRanges = []float64{1e-6, 10e-6, 100e-6, 1e-3, 10e-3, 100e-3, 1}
+	ke2400OVPLevels        = []float64{20, 40, 60, 80, 100, 120, 160, ke2400MaxVoltage}
+	ke2400Elements         = []string{"VOLT", "CURR", "RES", "TIME", "STAT"}
+)
+
+// Keithley2400Simulator is an in-process Keithley 2400 SourceMeter driving a DUT model.
+// It implements Transport, so it can be used as Session{Transport: sim}.
+type Keithley2400Simulator struct {
+	DUT    DUT
+	Model  string // "2400" by default
+	Serial string
+	scpiSimulator
+	state ke2400SimState
+	clock float64
+	ready bool
+}
+
+type ke2400SimState struct {
+	output      bool
+	offMode     string
+	sourceFunc  string
+	sourceMode  map[string]string
+	level       map[string]float64
+	sourceRange map[string]float64
+	sourceAuto  map[string]bool
+	senseFuncs  map[string]bool
+	senseRange  map[string]float64
+	senseAuto   map[string]bool
+	protection  map[string]float64
+	nplc        float64
+	ovp         float64
+	remoteSense bool
+	autoZero    string
+	autoDelay   bool
+	delay       float64
+	elements    []string
+
+	list         map[string][]float64
+	sweepStart   map[string]float64
+	sweepStop    map[string]float64
+	sweepPoints  int
+	sweepSpacing string
+	sweepRanging string
+	triggerCount int
+	tripped      bool // last reading is in compliance
+}
+
+func NewKeithley2400Simulator(dut DUT) *Keithley2400Simulator {
+
+	sim := &Keithley2400Simulator{DUT: dut}
+	sim.init()
+	return sim
+}
+
+func (sim *Keithley2400Simulator) init() {
+
+	if sim.Model == "" {
+		sim.Model = "2400"
+	}
+	if sim.Serial == "" {
+		sim.Serial = "1000000"
+	}
+	if sim.DUT == nil {
+		sim.DUT = OpenCircuit{}
+	}
+	sim.reset()
+	sim.handleCommon(func() string {
+		return fmt.Sprintf("KEITHLEY INSTRUMENTS INC.,MODEL %s,%s,C30   Mar 17 2006 09:29:29/A02  /K/J", sim.Model, sim.Serial)
+	}, sim.reset)
+
+	sim.handle("OUTPut[:STATe]", func(args string) (string, error) {
+		return "", parseSimBool(args, &sim.state.output)
+	})
+	sim.handle("OUTPut[:STATe]?", func(string) (string, error) { return formatSimBool(sim.state.output), nil })
+	sim.handle("OUTPut:SMODe", func(args string) (string, error) {
+		return "", parseSimChoice(args, &sim.state.offMode, "NORMal", "ZERO", "HIMPedance", "GUARd")
+	})
+	sim.handle("OUTPut:SMODe?", func(string) (string, error) { return sim.state.offMode, nil })
+
+	sim.handle("[SOURce]:FUNCtion[:MODE]", func(args string) (string, error) {
+		return "", parseSimChoice(args, &sim.state.sourceFunc, "VOLTage", "CURRent")
+	})
+	sim.handle("[SOURce]:FUNCtion[:MODE]?", func(string) (string, error) { return sim.state.sourceFunc, nil })
+	sim.handle("[SOURce]:DELay:AUTO", func(args string) (string, error) {
+		return "", parseSimBool(args, &sim.state.autoDelay)
+	})
+	sim.handle("[SOURce]:DELay", func(args string) (string, error) {
+		sim.state.autoDelay = false
+		return "", parseSimNumber(args, &sim.state.delay, 0, 9999.999, 0)
+	})
+	sim.handle("[SOURce]:SWEep:POINts", func(args string) (string, error) {
+		points := float64(sim.state.sweepPoints)
+		err := parseSimNumber(args, &points, 1, ke2400MaxSweepPoints, ke2400MaxSweepPoints)
+		sim.state.sweepPoints = int(points)
+		return "", err
+	})
+	sim.handle("[SOURce]:SWEep:POINts?", func(string) (string, error) {
+		return fmt.Sprintf("%d", sim.state.sweepPoints), nil
+	})
+	sim.handle("[SOURce]:SWEep:SPACing", func(args string) (string, error) {
+		return "", parseSimChoice(args, &sim.state.sweepSpacing, "LINear", "LOGarithmic")
+	})
+	sim.handle("[SOURce]:SWEep:RANGing", func(args string) (string, error) {
+		return "", parseSimChoice(args, &sim.state.sweepRanging, "BEST", "AUTO", "FIXed")
+	})
+	sim.handle("TRIGger:COUNt", func(args string) (string, error) {
+		count := float64(sim.state.triggerCount)
+		err := parseSimNumber(args, &count, 1, ke2400MaxSweepPoints, 1)
+		sim.state.triggerCount = int(count)
+		return "", err
+	})
+	sim.handle("TRIGger:COUNt?", func(string) (string, error) {
+		return fmt.Sprintf("%d", sim.state.triggerCount), nil
+	})
+	sim.handle("[SOURce]:VOLTage:PROTection[:LEVel]", sim.setOVP)
+	sim.handle("[SOURce]:VOLTage:PROTection[:LEVel]?", func(string) (string, error) {
+		return formatSimNumber(sim.state.ovp), nil
+	})
+
+	for _, function := range []string{"VOLT", "CURR"} {
+		function := function
+		long := map[string]string{"VOLT": "VOLTage", "CURR": "CURRent"}[function]
+		limit := map[string]float64{"VOLT": ke2400MaxVoltage, "CURR": ke2400MaxCurrent}[function]
+		ranges := map[string][]float64{"VOLT": ke2400SimVoltageRanges, "CURR": ke2400SimCurrentRanges}[function]
+
+		sim.handle("[SOURce]:"+long+"[:LEVel][:IMMediate][:AMPLitude]", func(args string) (string, error) {
+			return "", sim.setLevel(function, args, limit)
+		})
+		sim.handle("[SOURce]:"+long+"[:LEVel][:IMMediate][:AMPLitude]?", func(string) (string, error) {
+			return formatSimNumber(sim.state.level[function]), nil
+		})
+		sim.handle("[SOURce]:"+long+":MODE", func(args string) (string, error) {
+			mode := sim.state.sourceMode[function]
+			err := parseSimChoice(args, &mode, "FIXed", "LIST", "SWEep")
+			sim.state.sourceMode[function] = mode
+			return "", err
+		})
+		sim.handle("[SOURce]:"+long+":MODE?", func(string) (string, error) { return sim.state.sourceMode[function], nil })
+		sim.handle("[SOURce]:"+long+":STARt", func(args string) (string, error) {
+			value := sim.state.sweepStart[function]
+			err := parseSimNumber(args, &value, -limit, limit, 0)
+			sim.state.sweepStart[function] = value
+			return "", err
+		})
+		sim.handle("[SOURce]:"+long+":STOP", func(args string) (string, error) {
+			value := sim.state.sweepStop[function]
+			err := parseSimNumber(args, &value, -limit, limit, 0)
+			sim.state.sweepStop[function] = value
+			return "", err
+		})
+		sim.handle("[SOURce]:LIST:"+long+"[:VALues]", func(args string) (string, error) {
+			return "", sim.setList(function, args, limit, false)
+		})
+		sim.handle("[SOURce]:LIST:"+long+":APPend", func(args string) (string, error) {
+			return "", sim.setList(function, args, limit, true)
+		})
+		sim.handle("[SOURce]:LIST:"+long+":POINts?", func(string) (string, error) {
+			return fmt.Sprintf("%d", len(sim.state.list[function])), nil
+		})
+		sim.handle("[SOURce]:"+long+":STEP", func(args string) (string, error) {
+			return "", sim.setSweepStep(function, args, limit)
+		})
+		sim.handle("[SOURce]:"+long+":RANGe", func(args string) (string, error) {
+			return "", sim.setRange(sim.state.sourceRange, sim.state.sourceAuto, function, args, ranges)
+		})
+		sim.handle("[SOURce]:"+long+":RANGe?", func(string) (string, error) {
+			return formatSimNumber(sim.state.sourceRange[function]), nil
+		})
+		sim.handle("[SOURce]:"+long+":RANGe:AUTO", func(args string) (string, error) {
+			auto := sim.state.sourceAuto[function]
+			err := parseSimBool(args, &auto)
+			sim.state.sourceAuto[function] = auto
+			return "", err
+		})
+
+		sim.handle("[SENSe]:"+long+"[:DC]:RANGe[:UPPer]", func(args string) (string, error) {
+			return "", sim.setRange(sim.state.senseRange, sim.state.senseAuto, function, args, ranges)
+		})
+		sim.handle("[SENSe]:"+long+"[:DC]:RANGe[:UPPer]?", func(string) (string, error) {
+			return formatSimNumber(sim.state.senseRange[function]), nil
+		})
+		sim.handle("[SENSe]:"+long+"[:DC]:RANGe:AUTO", func(args string) (string, error) {
+			auto := sim.state.senseAuto[function]
+			err := parseSimBool(args, &auto)
+			sim.state.senseAuto[function] = auto
+			return "", err
+		})
+		sim.handle("[SENSe]:"+long+"[:DC]:PROTection[:LEVel]", func(args string) (string, error) {
+			value := sim.state.protection[function]
+			err := parseSimNumber(args, &value, -limit, limit, limit/10)
+			sim.state.protection[function] = math.Abs(value)
+			return "", err
+		})
+		sim.handle("[SENSe]:"+long+"[:DC]:PROTection[:LEVel]?", func(string) (string, error) {
+			return formatSimNumber(sim.state.protection[function]), nil
+		})
+		sim.handle("[SENSe]:"+long+"[:DC]:PROTection:TRIPped?", func(string) (string, error) {
+			return formatSimBool(sim.state.tripped && sim.state.sourceFunc != function), nil
+		})
+		sim.handle("[SENSe]:"+long+"[:DC]:NPLCycles", func(args string) (string, error) {
+			return "", parseSimNumber(args, &sim.state.nplc, 0.01, 10, 1)
+		})
+		sim.handle("[SENSe]:"+long+"[:DC]:NPLCycles?", func(string) (string, error) {
+			return formatSimNumber(sim.state.nplc), nil
+		})
+	}
+
+	sim.handle("[SENSe]:FUNCtion[:ON]", sim.setSenseFunctions)
+	sim.handle("[SENSe]:FUNCtion:CONCurrent", func(string) (string, error) { return "", nil })
+	sim.handle("[SENSe]:RESistance:NPLCycles", func(args string) (string, error) {
+		return "", parseSimNumber(args, &sim.state.nplc, 0.01, 10, 1)
+	})
+	sim.handle("SYSTem:RSENse", func(args string) (string, error) {
+		return "", parseSimBool(args, &sim.state.remoteSense)
+	})
+	sim.handle("SYSTem:AZERo[:STATe]", func(args string) (string, error) {
+		return "", parseSimChoice(args, &sim.state.autoZero, "ON", "OFF", "ONCE")
+	})
+	sim.handle("FORMat:ELEMents[:SENSe[1]]", sim.setElements)
+	sim.handle("FORMat:ELEMents[:SENSe[1]]?", func(string) (string, error) {
+		return strings.Join(sim.state.elements, ","), nil
+	})
+	sim.handle("READ?", sim.read)
+	sim.ready = true
+}
+
+func (sim *Keithley2400Simulator) Open() error {
+
+	if !sim.ready {
+		sim.init()
+	}
+	return sim.scpiSimulator.Open()
+}
+
+func (sim *Keithley2400Simulator) reset() {
+
+	sim.clock = 0
+	sim.state = ke2400SimState{
+		offMode:     "NORM",
+		sourceFunc:  "VOLT",
+		sourceMode:  map[string]string{"VOLT": "FIX", "CURR": "FIX"},
+		level:       map[string]float64{"VOLT": 0, "CURR": 0},
+		sourceRange: map[string]float64{"VOLT": 20, "CURR": 100e-6},
+		sourceAuto:  map[string]bool{"VOLT": true, "CURR": true},
+		senseFuncs:  map[string]bool{"CURR": true},
+		senseRange:  map[string]float64{"VOLT": 20, "CURR": 100e-6},
+		senseAuto:   map[string]bool{"VOLT": true, "CURR": true},
+		protection:  map[string]float64{"VOLT": 21, "CURR": 105e-6},
+		nplc:        1,
+		ovp:         ke2400MaxVoltage,
+		autoZero:    "ON",
+		autoDelay:   true,
+		elements:    append([]string(nil), ke2400Elements...),
+
+		list:         map[string][]float64{"VOLT": {0}, "CURR": {0}},
+		sweepStart:   map[string]float64{"VOLT": 0, "CURR": 0},
+		sweepStop:    map[string]float64{"VOLT": 0, "CURR": 0},
+		sweepPoints:  2500,
+		sweepSpacing: "LIN",
+		sweepRanging: "BEST",
+		triggerCount: 1,
+	}
+}
+
+func (sim *Keithley2400Simulator) setLevel(function, args string, limit float64) error {
+
+	level := sim.state.level[function]
+	err := parseSimNumber(args, &level, -limit, limit, 0)
+	if err != nil {
+		return err
+	}
+	if !sim.state.sourceAuto[function] && math.Abs(level) > 1.05*sim.state.sourceRange[function] {
+		return simError{code: scpiSettingsConflict}
+	}
+	sim.state.level[function] = level
+	if sim.state.sourceAuto[function] {
+		sim.state.sourceRange[function] = suitableSimRange(level, sim.sourceRanges(function))
+	}
+	return nil
+}
+
+func (sim *Keithley2400Simulator) sourceRanges(function string) []float64 {
+	if function == "VOLT" {
+		return ke2400SimVoltageRanges
+	}
+	return ke2400SimCurrentRanges
+}
+
+// Set range to the lowest one containing value, explicit range disables auto range.
+func (sim *Keithley2400Simulator) setRange(rng map[string]float64, auto map[string]bool, function, args string, ranges []float64) error {
+
+	value := rng[function]
+	err := parseSimNumber(args, &value, -ranges[len(ranges)-1]*1.05, ranges[len(ranges)-1]*1.05, ranges[len(ranges)-1])
+	if err != nil {
+		return err
+	}
+	rng[function] = suitableSimRange(value, ranges)
+	auto[function] = false
+	return nil
+}
+
+func (sim *Keithley2400Simulator) setOVP(args string) (string, error) {
+
+	if strings.EqualFold(args, "NONE") {
+		sim.state.ovp = ke2400MaxVoltage
+		return "", nil
+	}
+	value := sim.state.ovp
+	err := parseSimNumber(args, &value, -ke2400MaxVoltage, ke2400MaxVoltage, ke2400MaxVoltage)
+	if err != nil {
+		return "", err
+	}
+	sim.state.ovp = suitableSimRange(value, ke2400OVPLevels)
+	return "", nil
+}
+
+func (sim *Keithley2400Simulator) setSenseFunctions(args string) (string, error) {
+
+	if args == "" {
+		return "", simError{code: scpiMissingParameter}
+	}
+	for _, item := range strings.Split(args, ",") {
+		item = strings.Trim(strings.TrimSpace(item), "\"'")
+		item, _, _ = strings.Cut(item, ":")
+		var function string
+		err := parseSimChoice(item, &function, "VOLTage", "CURRent", "RESistance")
+		if err != nil {
+			return "", err
+		}
+		sim.state.senseFuncs[function] = true
+	}
+	return "", nil
+}
+
+// FORM:ELEM VOLT,CURR,RES,TIME,STAT in any order, output order is always fixed.
+func (sim *Keithley2400Simulator) setElements(args string) (string, error) {
+
+	if args == "" {
+		return "", simError{code: scpiMissingParameter}
+	}
+	selected := make(map[string]bool)
+	for _, item := range strings.Split(args, ",") {
+		var element string
+		err := parseSimChoice(strings.TrimSpace(item), &element, "VOLTage", "CURRent", "RESistance", "TIME", "STATus")
+		if err != nil {
+			return "", err
+		}
+		selected[element] = true
+	}
+	sim.state.elements = nil
+	for _, element := range ke2400Elements {
+		if selected[element] {
+			sim.state.elements = append(sim.state.elements, element)
+		}
+	}
+	return "", nil
+}
+
+func (sim *Keithley2400Simulator) read(string) (string, error) {
+
+	if !sim.state.output {
+		return "", simError{code: ke2400OutputOff, message: "Output disabled"}
+	}
+	readings := make([]string, sim.state.triggerCount)
+	for i := range readings {
+		if sim.state.autoDelay {
+			sim.clock += ke2400AutoDelay
+		} else {
+			sim.clock += sim.state.delay
+		}
+		readings[i] = sim.measure(sim.sourceLevel(i))
+	}
+	return strings.Join(readings, ","), nil
+}
+
+// Source level of i-th reading of a trigger sequence, sweep points are repeated if trigger
+// count exceeds them.
+func (sim *Keithley2400Simulator) sourceLevel(i int) float64 {
+
+	st := &sim.state
+	function := st.sourceFunc
+	switch st.sourceMode[function] {
+	case "LIST":
+		list := st.list[function]
+		return list[i%len(list)]
+	case "FIX":
+		return st.level[function]
+	}
+	start, stop, points := st.sweepStart[function], st.sweepStop[function], st.sweepPoints
+	if points < 2 {
+		return start
+	}
+	fraction := float64(i%points) / float64(points-1)
+	if st.sweepSpacing == "LOG" && start*stop > 0 {
+		return start * math.Pow(stop/start, fraction)
+	}
+	return start + (stop-start)*fraction
+}
+
+// Set or append source list, it holds up to 100 points.
+func (sim *Keithley2400Simulator) setList(function, args string, limit float64, appendValues bool) error {
+
+	if args == "" {
+		return simError{code: scpiMissingParameter}
+	}
+	var list []float64
+	if appendValues {
+		list = append(list, sim.state.list[function]...)
+	}
+	for _, item := range strings.Split(args, ",") {
+		var value float64
+		err := parseSimNumber(strings.TrimSpace(item), &value, -limit, limit, 0)
+		if err != nil {
+			return err
+		}
+		list = append(list, value)
+	}
+	if len(list) > ke2400MaxListPoints {
+		return simError{code: scpiTooMuchData}
+	}
+	sim.state.list[function] = list
+	return nil
+}
+
+// SOUR:VOLT:STEP and SOUR:CURR:STEP set number of sweep points from start and stop.
+func (sim *Keithley2400Simulator) setSweepStep(function, args string, limit float64) error {
+
+	var step float64
+	err := parseSimNumber(args, &step, -2*limit, 2*limit, 0)
+	if err != nil {
+		return err
+	}
+	span := math.Abs(sim.state.sweepStop[function] - sim.state.sweepStart[function])
+	if step == 0 || span/math.Abs(step)+1 > ke2400MaxSweepPoints {
+		return simError{code: scpiDataOutOfRange}
+	}
+	sim.state.sweepPoints = int(math.Floor(span/math.Abs(step)+1e-9)) + 1
+	return nil
+}
+
+// Take one reading of DUT at source level formatted according to FORM:ELEM.
+func (sim *Keithley2400Simulator) measure(level float64) string {
+
+	st := &sim.state
+	status := Status2400FrontTerminals
+	var voltage, current float64
+
+	if st.sourceFunc == "VOLT" {
+		status |= Status2400VoltageSource
+		voltage = level
+		if math.Abs(voltage) > st.ovp {
+			voltage = math.Copysign(st.ovp, voltage)
+			status |= Status2400OVP
+		}
+		current = sim.DUT.Current(voltage)
+		limit := st.protection["CURR"]
+		if math.IsNaN(current) || math.Abs(current) > limit {
+			current = math.Copysign(limit, current)
+			status |= Status2400Compliance
+			if v := sim.DUT.Voltage(current); !math.IsInf(v, 0) && !math.IsNaN(v) && math.Abs(v) < math.Abs(voltage) {
+				voltage = v
+			}
+		}
+	} else {
+		status |= Status2400CurrentSource
+		current = level
+		voltage = sim.DUT.Voltage(current)
+		limit := math.Min(st.protection["VOLT"], st.ovp)
+		if math.IsNaN(voltage) || math.Abs(voltage) > limit {
+			voltage = math.Copysign(limit, voltage)
+			status |= Status2400Compliance
+			if st.ovp < st.protection["VOLT"] {
+				status |= Status2400OVP
+			}
+			if i := sim.DUT.Current(voltage); !math.IsInf(i, 0) && !math.IsNaN(i) && math.Abs(i) < math.Abs(current) {
+				current = i
+			}
+		}
+	}
+
+	values := map[string]float64{"VOLT": voltage, "CURR": current, "RES": ke2400NotANumber}
+	for function, bit := range map[string]int{"VOLT": Status2400VoltageMeasure, "CURR": Status2400CurrentMeasure} {
+		if !st.senseFuncs[function] {
+			continue
+		}
+		status |= bit
+		if !st.senseAuto[function] && math.Abs(values[function]) > 1.05*st.senseRange[function] {
+			values[function] = math.Copysign(ke2400Overflow, values[function])
+			status |= Status2400RangeCompliance
+		}
+	}
+	if st.senseFuncs["RES"] {
+		status |= Status2400ResistanceMeasure
+		if current != 0 {
+			values["RES"] = voltage / current
+		}
+	}
+	if st.remoteSense {
+		status |= Status2400RemoteSense
+	}
+
+	st.tripped = status&Status2400Compliance != 0
+	sim.clock += st.nplc/ke2400LineFreq + ke2400ReadOverhead
+	values["TIME"] = sim.clock
+	values["STAT"] = float64(status)
+
+	fields := make([]string, len(st.elements))
+	for i, element := range st.elements {
+		fields[i] = fmt.Sprintf("%+E", values[element])
+	}
+	return strings.Join(fields, ",")
+}
+
+func suitableSimRange(value float64, ranges []float64) float64 {
+
+	for _, rng := range ranges {
+		if math.Abs(value) <= rng*1.05 {
+			return rng
+		}
+	}
+	return ranges[len(ranges)-1]
+}
+
+// Parse numeric parameter with MINimum, MAXimum and DEFault keywords.
+func parseSimNumber(args string, value *float64, min, max, def float64) error {
+
+	switch strings.ToUpper(args) {
+	case "":
+		return simError{code: scpiMissingParameter}
+	case "MIN", "MINIMUM":
+		*value = min
+		return nil
+	case "MAX", "MAXIMUM":
+		*value = max
+		return nil
+	case "DEF", "DEFAULT":
+		*value = def
+		return nil
+	}
+	number, err := strconv.ParseFloat(args, 64)
+	if err != nil {
+		return simError{code: scpiDataTypeError}
+	}
+	if number < min || number > max {
+		return simError{code: scpiDataOutOfRange}
+	}
+	*value = number
+	return nil
+}
+
+func parseSimBool(args string, value *bool) error {
+
+	switch strings.ToUpper(args) {
+	case "ON", "1":
+		*value = true
+	case "OFF", "0":
+		*value = false
+	case "":
+		return simError{code: scpiMissingParameter}
+	default:
+		return simError{code: scpiIllegalParameter}
+	}
+	return nil
+}
+
+func formatSimBool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func formatSimNumber(value float64) string {
+	return fmt.Sprintf("%+E", value)
+}
+
+// Parse character parameter, choices are written in SCPI short/long form, value gets the short form.
+func parseSimChoice(args string, value *string, choices ...string) error {
+
+	if args == "" {
+		return simError{code: scpiMissingParameter}
+	}
+	for _, choice := range choices {
+		node := newSimNode(choice)
+		if node.match(args) {
+			*value = node.short
+			return nil
+		}
+	}
+	return simError{code: scpiIllegalParameter}
+}
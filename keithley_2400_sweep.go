@@ -0,0 +1,331 @@
+// Развёртки источника-измерителя Keithley 2400 (раздел 10 "Sweep Operation" руководства)
+
+package instruments
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ke2400MaxSweepPoints = 2500 // предел SOUR:SWE:POIN и TRIG:COUN
+	ke2400MaxListPoints  = 100  // предел SOUR:LIST:VOLT и SOUR:LIST:CURR
+
+	ke2400RestoreTimeout = 2 * time.Second // ограничение восстановления источника после развёртки
+)
+
+// Функция источника.
+type SourceFunction string
+
+const (
+	SourceVoltage SourceFunction = "VOLT"
+	SourceCurrent SourceFunction = "CURR"
+)
+
+// Измеряемая функция: ток для источника напряжения и напряжение для источника тока.
+func (function SourceFunction) sense() string {
+	if function == SourceCurrent {
+		return "VOLT"
+	}
+	return "CURR"
+}
+
+// Распределение точек ступенчатой развёртки.
+type SweepSpacing string
+
+const (
+	SweepLinear      SweepSpacing = "LIN"
+	SweepLogarithmic SweepSpacing = "LOG"
+)
+
+// Общие параметры развёрток.
+type SweepConfig struct {
+	Function SourceFunction // SourceVoltage по умолчанию
+	Limit    float64        // ограничение измеряемой величины (SENS:*:PROT)
+	NPLC     float64        // время интегрирования в периодах сети, 1 по умолчанию
+	Delay    time.Duration  // задержка источника перед измерением, автоматическая если 0
+	Remote   bool           // четырёхпроводная схема
+}
+
+// Ступенчатая развёртка от Start до Stop с шагом Step или числом точек Points.
+// Логарифмическая развёртка задаётся только числом точек.
+type StaircaseSweep struct {
+	SweepConfig
+	Start   float64
+	Stop    float64
+	Step    float64
+	Points  int
+	Spacing SweepSpacing // SweepLinear по умолчанию
+}
+
+// Число точек развёртки.
+func (sweep *StaircaseSweep) points() (int, error) {
+
+	spacing := sweep.Spacing
+	if spacing == "" {
+		spacing = SweepLinear
+	}
+	if spacing != SweepLinear && spacing != SweepLogarithmic {
+		return 0, fmt.Errorf("unknown sweep spacing \"%s\"", spacing)
+	}
+	if spacing == SweepLogarithmic && (sweep.Start*sweep.Stop <= 0) {
+		return 0, fmt.Errorf("log sweep from %g to %g crosses zero", sweep.Start, sweep.Stop)
+	}
+	points := sweep.Points
+	if points == 0 {
+		if spacing == SweepLogarithmic || sweep.Step == 0 {
+			return 0, errors.New("number of sweep points is not set")
+		}
+		points = int(math.Floor(math.Abs(sweep.Stop-sweep.Start)/math.Abs(sweep.Step)+1e-9)) + 1
+	}
+	if points < 2 || points > ke2400MaxSweepPoints {
+		return 0, fmt.Errorf("number of sweep points %d is out of range 2..%d", points, ke2400MaxSweepPoints)
+	}
+	return points, nil
+}
+
+// Выполнить ступенчатую развёртку за один запуск триггера и считать все отсчёты.
+// Выход включается на время развёртки и затем возвращается в прежнее состояние, источник — в режим
+// постоянного уровня, в том числе после отмены ctx.
+// В режиме SetComplianceError отсчёты возвращаются и вместе с ComplianceError.
+func (ke2400 *Keithley2400) Sweep(sweep StaircaseSweep) ([]Reading, error) {
+	return ke2400.SweepContext(context.Background(), sweep)
+}
+
+// Sweep с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) SweepContext(ctx context.Context, sweep StaircaseSweep) ([]Reading, error) {
+
+	errContext := "staircase sweep fail"
+	points, err := sweep.points()
+	if err != nil {
+		return nil, errors.Wrap(err, errContext)
+	}
+
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	instr := bindContext(ctx, ke2400.instr)
+	function := sweep.Function
+	if function == "" {
+		function = SourceVoltage
+	}
+	spacing := sweep.Spacing
+	if spacing == "" {
+		spacing = SweepLinear
+	}
+	commands := []string{
+		fmt.Sprintf("SOUR:%s:STAR %g", function, sweep.Start),
+		fmt.Sprintf("SOUR:%s:STOP %g", function, sweep.Stop),
+		fmt.Sprintf("SOUR:SWE:SPAC %s", spacing),
+	}
+	if sweep.Points == 0 {
+		commands = append(commands, fmt.Sprintf("SOUR:%s:STEP %g", function, sweep.Step))
+	} else {
+		commands = append(commands, fmt.Sprintf("SOUR:SWE:POIN %d", points))
+	}
+	commands = append(commands, "SOUR:SWE:RANG BEST", fmt.Sprintf("SOUR:%s:MODE SWE", function))
+	err = ke2400.configureSweep(instr, sweep.SweepConfig, commands)
+	if err != nil {
+		return nil, errors.Wrap(err, errContext)
+	}
+	var readings []Reading
+	err = ke2400.runSweep(ctx, function, func() error {
+		readings, err = ke2400.readSweep(instr, points)
+		return err
+	})
+	return sweepResult(readings, err, errContext)
+}
+
+// Выполнить развёртку по списку точек источника и считать отсчёты, i-й отсчёт соответствует points[i].
+// Списки длиннее 100 точек выполняются частями, выход между частями не выключается. Состояние выхода
+// и режим источника восстанавливаются как в Sweep.
+func (ke2400 *Keithley2400) ListSweep(config SweepConfig, points []float64) ([]Reading, error) {
+	return ke2400.ListSweepContext(context.Background(), config, points)
+}
+
+// ListSweep с ограничением по времени и отменой через ctx.
+func (ke2400 *Keithley2400) ListSweepContext(ctx context.Context, config SweepConfig, points []float64) ([]Reading, error) {
+
+	errContext := "list sweep fail"
+	if len(points) == 0 {
+		return nil, errors.Wrap(errors.New("list of source points is empty"), errContext)
+	}
+
+	ctx, unlock, err := lockInstr(ctx, ke2400.instr)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	instr := bindContext(ctx, ke2400.instr)
+	function := config.Function
+	if function == "" {
+		function = SourceVoltage
+	}
+	err = ke2400.configureSweep(instr, config, []string{fmt.Sprintf("SOUR:%s:RANG:AUTO ON", function)})
+	if err != nil {
+		return nil, errors.Wrap(err, errContext)
+	}
+	readings := make([]Reading, 0, len(points))
+	err = ke2400.runSweep(ctx, function, func() error {
+		for start := 0; start < len(points); start += ke2400MaxListPoints {
+			chunk := points[start:min(start+ke2400MaxListPoints, len(points))]
+			values := make([]string, len(chunk))
+			for i, point := range chunk {
+				values[i] = strconv.FormatFloat(point, 'g', -1, 64)
+			}
+			err := instr.Write(fmt.Sprintf("SOUR:LIST:%s %s", function, strings.Join(values, ",")))
+			if err == nil && start == 0 {
+				err = instr.Write(fmt.Sprintf("SOUR:%s:MODE LIST", function))
+			}
+			if err != nil {
+				return errors.Wrapf(err, "list of points %d..%d", start, start+len(chunk)-1)
+			}
+			chunkReadings, err := ke2400.readSweep(instr, len(chunk))
+			readings = append(readings, chunkReadings...)
+			var complianceErr *ComplianceError
+			if errors.As(err, &complianceErr) {
+				complianceErr.Index += start
+				return err
+			}
+			if err != nil {
+				return errors.Wrapf(err, "points %d..%d", start, start+len(chunk)-1)
+			}
+		}
+		return nil
+	})
+	return sweepResult(readings, err, errContext)
+}
+
+// Отсчёты развёртки возвращаются вместе с ComplianceError, при других ошибках они не возвращаются.
+func sweepResult(readings []Reading, err error, errContext string) ([]Reading, error) {
+
+	var complianceErr *ComplianceError
+	if errors.As(err, &complianceErr) {
+		return readings, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errContext)
+	}
+	return readings, nil
+}
+
+// Настроить измерение и задержку, затем источник командами source.
+func (ke2400 *Keithley2400) configureSweep(instr Instrument, config SweepConfig, source []string) error {
+
+	function := config.Function
+	if function == "" {
+		function = SourceVoltage
+	}
+	if function != SourceVoltage && function != SourceCurrent {
+		return fmt.Errorf("unknown source function \"%s\"", function)
+	}
+	if config.Limit <= 0 {
+		return errors.New("compliance limit is not set")
+	}
+	nplc := config.NPLC
+	if nplc == 0 {
+		nplc = 1
+	}
+	err := instr.Write(fmt.Sprintf("SOUR:FUNC %s", function))
+	if err != nil {
+		return err
+	}
+	ke2400.source = function
+
+	sense := function.sense()
+	commands := []string{
+		fmt.Sprintf("SENS:FUNC \"%s:DC\"", sense),
+		fmt.Sprintf("SENS:%s:RANG:AUTO ON", sense),
+		fmt.Sprintf("SENS:%s:PROT %g", sense, config.Limit),
+		fmt.Sprintf("SENS:%s:NPLC %g", sense, nplc),
+	}
+	if config.Delay == 0 {
+		commands = append(commands, "SOUR:DEL:AUTO ON")
+	} else {
+		commands = append(commands, fmt.Sprintf("SOUR:DEL %g", config.Delay.Seconds()))
+	}
+	if config.Remote {
+		commands = append(commands, "SYST:RSEN ON")
+	} else {
+		commands = append(commands, "SYST:RSEN OFF")
+	}
+	for _, cmd := range append(commands, source...) {
+		err = instr.Write(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Включить выход на время run, затем вернуть выходу прежнее состояние, а источнику режим постоянного
+// уровня. Восстановление выполняется и после отмены ctx, его ошибка возвращается вместе с ошибкой run.
+func (ke2400 *Keithley2400) runSweep(ctx context.Context, function SourceFunction, run func() error) error {
+
+	instr := bindContext(ctx, ke2400.instr)
+	response, err := instr.Query("OUTP?")
+	if err != nil {
+		return errors.Wrap(err, "output state read fail")
+	}
+	outputOn := strings.TrimSpace(response) == "1"
+	if !outputOn {
+		err = instr.Write("OUTP ON")
+		if err != nil {
+			return err
+		}
+	}
+	err = run()
+
+	// Restore DC source even if the sweep failed or ctx is done
+	restoreCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ke2400RestoreTimeout)
+	defer cancel()
+	restore := bindContext(restoreCtx, ke2400.instr)
+	commands := []string{fmt.Sprintf("SOUR:%s:MODE FIX", function), "TRIG:COUN 1"}
+	if !outputOn {
+		commands = append([]string{"OUTP OFF"}, commands...)
+	}
+	var restoreErr error
+	for _, cmd := range commands {
+		if cmdErr := restore.Write(cmd); cmdErr != nil && restoreErr == nil {
+			restoreErr = errors.Wrap(cmdErr, "source restore fail")
+		}
+	}
+	if err != nil && restoreErr != nil {
+		return fmt.Errorf("%w; %w", err, restoreErr)
+	}
+	if err != nil {
+		return err
+	}
+	return restoreErr
+}
+
+// Запустить points триггеров и считать отсчёты.
+func (ke2400 *Keithley2400) readSweep(instr Instrument, points int) ([]Reading, error) {
+
+	err := instr.Write(fmt.Sprintf("TRIG:COUN %d", points))
+	if err != nil {
+		return nil, err
+	}
+	response, err := instr.Query(":READ?")
+	if err != nil {
+		return nil, errors.Wrap(err, "data read fail")
+	}
+	readings, err := parseReadings(response, ke2400.elements)
+	if err != nil {
+		return nil, err
+	}
+	if len(readings) != points {
+		return nil, fmt.Errorf("%d readings received instead of %d", len(readings), points)
+	}
+	return readings, ke2400.checkCompliance(instr, readings)
+}
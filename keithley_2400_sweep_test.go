@@ -0,0 +1,212 @@
+package instruments
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeithley2400Sweep(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+
+	readings, err := ke2400.Sweep(StaircaseSweep{
+		SweepConfig: SweepConfig{Limit: 1e-3, Delay: 10 * time.Millisecond},
+		Start:       0,
+		Stop:        2,
+		Step:        0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 5 {
+		t.Fatalf("expected 5 readings, got %d", len(readings))
+	}
+	for i, reading := range readings {
+		voltage := 0.5 * float64(i)
+		compliance := voltage > 1
+		if !compliance && (math.Abs(reading.Voltage-voltage) > 1e-9 || math.Abs(reading.Current-voltage/1000) > 1e-12) {
+			t.Errorf("point %d: read %g V, %g A", i, reading.Voltage, reading.Current)
+		}
+		if (reading.Status&Status2400Compliance != 0) != compliance || reading.Status&Status2400VoltageSource == 0 {
+			t.Errorf("point %d: unexpected status %#x", i, reading.Status)
+		}
+		if i > 0 && reading.Time-readings[i-1].Time < 10*time.Millisecond {
+			t.Errorf("point %d: source delay isn't applied, time %v after %v", i, reading.Time, readings[i-1].Time)
+		}
+	}
+	for cmd, expected := range map[string]string{"OUTP?": "0", "TRIG:COUN?": "1"} {
+		response, err := session.Query(cmd)
+		if err != nil || response != expected {
+			t.Errorf("%s: expected %s after sweep, got %q, %v", cmd, expected, response, err)
+		}
+	}
+
+	readings, err = ke2400.Sweep(StaircaseSweep{
+		SweepConfig: SweepConfig{Function: SourceCurrent, Limit: 10},
+		Start:       1e-6,
+		Stop:        1e-3,
+		Points:      4,
+		Spacing:     SweepLogarithmic,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, current := range []float64{1e-6, 1e-5, 1e-4, 1e-3} {
+		if i >= len(readings) || math.Abs(readings[i].Current-current) > current*1e-9 ||
+			math.Abs(readings[i].Voltage-current*1000) > current*1e-6 {
+			t.Errorf("unexpected log sweep readings %+v", readings)
+			break
+		}
+	}
+}
+
+// Query source state after sweep, the DC source must be restored.
+func checkSweepRestored(t *testing.T, session *Session, output string) {
+
+	for cmd, expected := range map[string]string{"OUTP?": output, "SOUR:VOLT:MODE?": "FIX", "TRIG:COUN?": "1"} {
+		response, err := session.Query(cmd)
+		if err != nil || response != expected {
+			t.Errorf("%s: expected %s after sweep, got %q, %v", cmd, expected, response, err)
+		}
+	}
+}
+
+func TestKeithley2400SweepCanceled(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+	sweep := StaircaseSweep{SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0, Stop: 1, Points: 5}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session.Observer = IOObserverFunc(func(event IOEvent) {
+		if event.Op == IOWrite && string(event.Data) == "TRIG:COUN 5" {
+			cancel()
+		}
+	})
+	_, err := ke2400.SweepContext(ctx, sweep)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+	session.Observer = nil
+	checkSweepRestored(t, session, "0")
+
+	// Output switched on before the sweep stays on
+	err = ke2400.SetOutput(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ke2400.Sweep(sweep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSweepRestored(t, session, "1")
+}
+
+func TestKeithley2400SweepValidation(t *testing.T) {
+
+	ke2400, _ := newSimulatedKeithley2400(t, Resistor{1000})
+
+	for name, sweep := range map[string]StaircaseSweep{
+		"no points":       {SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0, Stop: 1},
+		"log step":        {SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0.1, Stop: 1, Step: 0.1, Spacing: SweepLogarithmic},
+		"log zero":        {SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0, Stop: 1, Points: 5, Spacing: SweepLogarithmic},
+		"too many":        {SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0, Stop: 1, Step: 1e-4},
+		"no limit":        {Start: 0, Stop: 1, Points: 5},
+		"bad function":    {SweepConfig: SweepConfig{Function: "RES", Limit: 1e-3}, Start: 0, Stop: 1, Points: 5},
+		"out of range":    {SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0, Stop: 500, Points: 5},
+		"unknown spacing": {SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0, Stop: 1, Points: 5, Spacing: "EXP"},
+	} {
+		_, err := ke2400.Sweep(sweep)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	_, err := parseReadings("+1.0E+00,+1.0E-03,+9.91E+37,+1.0E+00", ElementsAll)
+	if err == nil {
+		t.Error("expected error for incomplete reading")
+	}
+}
+
+func TestKeithley2400ListSweep(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+	var outputWrites []string
+	session.Observer = IOObserverFunc(func(event IOEvent) {
+		if event.Op == IOWrite && strings.HasPrefix(string(event.Data), "OUTP ") {
+			outputWrites = append(outputWrites, string(event.Data))
+		}
+	})
+
+	// Dense near 0.6 V, more points than a single list holds
+	var points []float64
+	for i := 0; i < 250; i++ {
+		points = append(points, 0.6+math.Pow(float64(i-125)/125, 3))
+	}
+	readings, err := ke2400.ListSweep(SweepConfig{Limit: 0.1, NPLC: 0.1}, points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != len(points) {
+		t.Fatalf("expected %d readings, got %d", len(points), len(readings))
+	}
+	for i, reading := range readings {
+		if math.Abs(reading.Voltage-points[i]) > 1e-6 || math.Abs(reading.Current-points[i]/1000) > 1e-9 {
+			t.Fatalf("point %d: read %g V, %g A for %g V", i, reading.Voltage, reading.Current, points[i])
+		}
+		if i > 0 && reading.Time <= readings[i-1].Time {
+			t.Fatalf("point %d: time %v isn't after %v", i, reading.Time, readings[i-1].Time)
+		}
+	}
+	if strings.Join(outputWrites, "|") != "OUTP ON|OUTP OFF" {
+		t.Errorf("output must be switched once per list sweep, got %v", outputWrites)
+	}
+
+	_, err = ke2400.ListSweep(SweepConfig{Limit: 0.1}, nil)
+	if err == nil {
+		t.Error("expected error for empty list")
+	}
+	_, err = ke2400.ListSweep(SweepConfig{Function: SourceCurrent, Limit: 10}, []float64{1e-3, 2}) // 2 A is out of range
+	if err == nil || !strings.Contains(err.Error(), "-222") {
+		t.Errorf("expected data out of range error, got %v", err)
+	}
+	err = session.Write("SOUR:LIST:VOLT " + strings.Repeat("1,", 100) + "1")
+	if err == nil || !strings.Contains(err.Error(), "-223") {
+		t.Errorf("expected too much data error, got %v", err)
+	}
+}
+
+func TestKeithley2400ListSweepCanceled(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+	points := make([]float64, 250)
+	for i := range points {
+		points[i] = float64(i) / 250
+	}
+
+	// Cancel while the second list is loaded
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lists := 0
+	session.Observer = IOObserverFunc(func(event IOEvent) {
+		if event.Op == IOWrite && strings.HasPrefix(string(event.Data), "SOUR:LIST:VOLT ") {
+			lists++
+			if lists == 2 {
+				cancel()
+			}
+		}
+	})
+	_, err := ke2400.ListSweepContext(ctx, SweepConfig{Limit: 0.1}, points)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+	if lists != 2 {
+		t.Errorf("expected sweep to stop at the second list, %d lists loaded", lists)
+	}
+	session.Observer = nil
+	checkSweepRestored(t, session, "0")
+}
@@ -0,0 +1,414 @@
+package instruments
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Open simulated Keithley 2400 with dut and initialize driver.
+func newSimulatedKeithley2400(t *testing.T, dut DUT) (*Keithley2400, *Session) {
+
+	session := &Session{Transport: NewKeithley2400Simulator(dut)}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+
+	ke2400 := &Keithley2400{}
+	err = ke2400.Init(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ke2400, session
+}
+
+// Read raw :READ? fields (VOLT,CURR,RES,TIME,STAT).
+func readSimulated(t *testing.T, session *Session) (voltage, current float64, status int) {
+
+	response, err := session.Query(":READ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Split(response, ",")
+	if len(fields) != 5 {
+		t.Fatalf("unexpected :READ? response %q", response)
+	}
+	voltage, _ = strconv.ParseFloat(fields[0], 64)
+	current, _ = strconv.ParseFloat(fields[1], 64)
+	stat, _ := strconv.ParseFloat(fields[4], 64)
+	return voltage, current, int(stat)
+}
+
+func TestKeithley2400Simulated(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		dut        DUT
+		voltage    bool
+		level      float64
+		limit      float64
+		expectV    float64
+		expectI    float64
+		compliance bool
+	}{
+		{"resistor", Resistor{1000}, true, 1, 10e-3, 1, 1e-3, false},
+		{"resistor in compliance", Resistor{1000}, true, 5, 1e-3, 1, 1e-3, true},
+		{"resistor current source", Resistor{1000}, false, 2e-3, 10, 2, 2e-3, false},
+		{"diode forward", Diode{}, false, 1e-3, 10, 0.02585 * math.Log1p(1e-3/1e-12), 1e-3, false},
+		{"diode reverse", Diode{}, true, -5, 1e-3, -5, -1e-12, false},
+		{"open", OpenCircuit{}, false, 1e-3, 10, 10, 0, true},
+		{"short", ShortCircuit{}, true, 1, 0.1, 0, 0.1, true},
+	}
+	for _, test := range tests {
+		ke2400, session := newSimulatedKeithley2400(t, test.dut)
+		var err error
+		if test.voltage {
+			err = ke2400.SetFixedRangeVoltageSource(test.level, test.limit, 1, false)
+		} else {
+			err = ke2400.SetFixedRangeCurrentSource(test.level, test.limit, 1, false)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		err = session.Write("OUTP ON")
+		if err != nil {
+			t.Fatal(err)
+		}
+		voltage, current, status := readSimulated(t, session)
+		if math.Abs(voltage-test.expectV) > 1e-6 || math.Abs(current-test.expectI) > 1e-9 {
+			t.Errorf("%s: read %g V, %g A, expected %g V, %g A", test.name, voltage, current, test.expectV, test.expectI)
+		}
+		if (status&Status2400Compliance != 0) != test.compliance {
+			t.Errorf("%s: unexpected compliance state in status %#x", test.name, status)
+		}
+	}
+}
+
+func TestKeithley2400SimulatedErrors(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+
+	// Output is off after *RST
+	_, _, err := ke2400.ReadSrcData()
+	if err == nil || !strings.Contains(err.Error(), "Output disabled") {
+		t.Errorf("expected output disabled error, got %v", err)
+	}
+
+	err = ke2400.SetAutoRangeVoltageSource(1, 1e-3, 100, false)
+	if err == nil || !strings.Contains(err.Error(), "-222") {
+		t.Errorf("expected data out of range error for NPLC, got %v", err)
+	}
+	err = ke2400.SetAutoRangeCurrentSource(2, 10, 1, false)
+	if err == nil || !strings.Contains(err.Error(), "-222") {
+		t.Errorf("expected data out of range error for current, got %v", err)
+	}
+	err = session.Write("SOUR:VOLT:MODE ZIGZAG")
+	if err == nil || !strings.Contains(err.Error(), "-224") {
+		t.Errorf("expected illegal parameter error, got %v", err)
+	}
+	err = session.Write("SOUR:CURR:MODE AUTO") // auto range is SOUR:CURR:RANG:AUTO
+	if err == nil || !strings.Contains(err.Error(), "-224") {
+		t.Errorf("expected illegal parameter error for AUTO source mode, got %v", err)
+	}
+	err = session.Write("SOUR:BOGUS 1")
+	if err == nil || !strings.Contains(err.Error(), "-113") {
+		t.Errorf("expected undefined header error, got %v", err)
+	}
+
+	// Valid settings after errors
+	err = ke2400.SetAutoRangeVoltageSource(1, 1e-3, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Write("OUTP ON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	voltage, current, status := readSimulated(t, session)
+	if voltage != 1 || current != 1e-3 || status&Status2400RemoteSense == 0 {
+		t.Errorf("unexpected reading %g V, %g A, status %#x", voltage, current, status)
+	}
+}
+
+func TestKeithley2400Readings(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+	err := ke2400.SetFixedRangeVoltageSource(1, 10e-3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Write("OUTP ON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, voltage, err := ke2400.ReadSrcData()
+	if err != nil || current != 1e-3 || voltage != 1 {
+		t.Errorf("unexpected source data %g A, %g V, %v", current, voltage, err)
+	}
+
+	// Multiple readings of a trigger sequence
+	err = session.Write("TRIG:COUN 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := ke2400.ReadReadings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 3 {
+		t.Fatalf("expected 3 readings, got %d", len(readings))
+	}
+	for i, reading := range readings {
+		if reading.Elements != ElementsAll || reading.Voltage != 1 || reading.Current != 1e-3 ||
+			reading.Resistance != ke2400NotANumber || reading.Status.Compliance() ||
+			reading.Status&Status2400VoltageSource == 0 || i > 0 && reading.Time <= readings[i-1].Time {
+			t.Errorf("unexpected reading %d: %+v", i, reading)
+		}
+	}
+
+	// Reduced elements in compliance
+	err = ke2400.SetFixedRangeVoltageSource(5, 1e-3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ke2400.SetReadingElements(ElementStatus | ElementCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err = ke2400.ReadReadings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 3 || readings[0].Elements != ElementCurrent|ElementStatus || readings[0].Voltage != 0 ||
+		readings[0].Current != 1e-3 || !readings[0].Status.Compliance() {
+		t.Errorf("unexpected readings %+v", readings)
+	}
+	_, _, err = ke2400.ReadSrcData()
+	if err == nil {
+		t.Error("source data can't be read without voltage element")
+	}
+	if ke2400.SetReadingElements(0) == nil {
+		t.Error("expected error for empty reading elements")
+	}
+}
+
+func TestParseReadings(t *testing.T) {
+
+	readings, err := parseReadings("+2.000000E+00,+1.500000E+00,+1.147120E+05", ElementVoltage|ElementTime|ElementStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 1 || readings[0].Voltage != 2 || readings[0].Time != 1500*time.Millisecond ||
+		readings[0].Status != Status2400Compliance|Status2400OVP|Status2400VoltageSource|Status2400CurrentSource|Status2400RangeCompliance {
+		t.Errorf("unexpected readings %+v", readings)
+	}
+	if s := readings[0].Status.String(); s != "CMPL|OVP|V_SOUR|I_SOUR|RANGE_CMPL" {
+		t.Errorf("unexpected status names %s", s)
+	}
+	if !readings[0].Status.OverVoltage() || !readings[0].Status.RangeCompliance() || readings[0].Status.Filter() {
+		t.Errorf("unexpected status decoding of %s", readings[0].Status)
+	}
+
+	for _, response := range []string{"+1.0E+00,+2.0E+00", "+1.0E+00,+2.0E+00,+3.0E+00,+4.0E+00", "+1.0E+00,foo,+3.0E+00"} {
+		_, err := parseReadings(response, ElementVoltage|ElementTime|ElementStatus)
+		if err == nil {
+			t.Errorf("%q: expected error", response)
+		}
+	}
+}
+
+func TestKeithley2400Compliance(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+	err := ke2400.SetFixedRangeVoltageSource(5, 1e-3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Write("OUTP ON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := ke2400.ReadReadings()
+	if err != nil || len(readings) != 1 || !readings[0].Compliance {
+		t.Errorf("compliance isn't detected by status word: %+v, %v", readings, err)
+	}
+
+	// Without status word compliance is queried with SENS:CURR:PROT:TRIP?
+	err = ke2400.SetReadingElements(ElementVoltage | ElementCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err = ke2400.ReadReadings()
+	if err != nil || len(readings) != 1 || !readings[0].Compliance {
+		t.Errorf("compliance isn't detected by trip state: %+v, %v", readings, err)
+	}
+
+	ke2400.SetComplianceError(true)
+	current, voltage, err := ke2400.ReadSrcData()
+	var complianceErr *ComplianceError
+	if !errors.As(err, &complianceErr) || complianceErr.Function != "CURR" || complianceErr.Limit != 1e-3 ||
+		!complianceErr.Reading.Compliance {
+		t.Errorf("expected current compliance error, got %v", err)
+	}
+	if current != 1e-3 || voltage != 1 {
+		t.Errorf("readings must be returned with compliance error, got %g A, %g V", current, voltage)
+	}
+
+	err = ke2400.SetFixedRangeVoltageSource(0.5, 1e-3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err = ke2400.ReadReadings()
+	if err != nil || readings[0].Compliance {
+		t.Errorf("unexpected compliance: %+v, %v", readings, err)
+	}
+
+	err = ke2400.SetReadingElements(ElementsAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ke2400.SetFixedRangeCurrentSource(20e-3, 10, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ke2400.ReadReadings()
+	if !errors.As(err, &complianceErr) || complianceErr.Function != "VOLT" || complianceErr.Limit != 10 {
+		t.Errorf("expected voltage compliance error, got %v", err)
+	}
+
+	// Sweeps return readings with the error of the first point in compliance
+	readings, err = ke2400.Sweep(StaircaseSweep{SweepConfig: SweepConfig{Limit: 1e-3}, Start: 0, Stop: 2, Step: 0.5})
+	if !errors.As(err, &complianceErr) || complianceErr.Index != 3 || len(readings) != 5 {
+		t.Errorf("expected compliance error at point 3, got %v, %d readings", err, len(readings))
+	}
+	points := make([]float64, 150)
+	points[120] = 2
+	readings, err = ke2400.ListSweep(SweepConfig{Limit: 1e-3}, points)
+	if !errors.As(err, &complianceErr) || complianceErr.Index != 120 || len(readings) != 150 {
+		t.Errorf("expected compliance error at point 120, got %v, %d readings", err, len(readings))
+	}
+}
+
+func TestKeithley2400Output(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+	err := ke2400.SetOutputOffMode(OutputOffHighImpedance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ke2400.SetAutoRangeVoltageSource(0, 10e-3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := session.Query("OUTP:SMOD?")
+	if err != nil || response != "HIMP" {
+		t.Errorf("output off mode must survive source setup, got %q, %v", response, err)
+	}
+	if ke2400.SetOutputOffMode("OPEN") == nil {
+		t.Error("expected error for unknown output off mode")
+	}
+
+	for _, on := range []bool{true, false, true} {
+		err = ke2400.SetOutput(on)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := ke2400.GetOutput()
+		if err != nil || state != on {
+			t.Errorf("expected output %v, got %v, %v", on, state, err)
+		}
+	}
+}
+
+func TestKeithley2400RampTo(t *testing.T) {
+
+	ke2400, session := newSimulatedKeithley2400(t, Resistor{1000})
+	var levels []float64
+	events := 0
+	session.Observer = IOObserverFunc(func(event IOEvent) {
+		events++
+		if value, ok := strings.CutPrefix(string(event.Data), "SOUR:VOLT "); ok && event.Op == IOWrite {
+			level, _ := strconv.ParseFloat(value, 64)
+			levels = append(levels, level)
+		}
+	})
+	err := ke2400.SetAutoRangeVoltageSource(0, 10e-3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Output is off, level is set at once
+	levels = nil
+	err = ke2400.RampTo(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 1 || levels[0] != 1 {
+		t.Errorf("expected single level write with output off, got %v", levels)
+	}
+
+	err = ke2400.SetOutput(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels = nil
+	start := time.Now()
+	err = ke2400.RampTo(3, 10) // 0.5 V steps
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	expected := []float64{1.5, 2, 2.5, 3}
+	if len(levels) != len(expected) {
+		t.Fatalf("expected levels %v, got %v", expected, levels)
+	}
+	for i := range expected {
+		if math.Abs(levels[i]-expected[i]) > 1e-9 {
+			t.Fatalf("expected levels %v, got %v", expected, levels)
+		}
+	}
+	if elapsed < 3*ke2400RampInterval {
+		t.Errorf("ramp is too fast: %v", elapsed)
+	}
+	response, err := session.Query("SOUR:VOLT?")
+	if err != nil || response != formatSimNumber(3) {
+		t.Errorf("expected level 3 V after ramp, got %q, %v", response, err)
+	}
+
+	for _, slewRate := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if ke2400.RampTo(0, slewRate) == nil {
+			t.Errorf("expected error for slew rate %g", slewRate)
+		}
+	}
+	// Non-finite level is rejected before any I/O
+	events = 0
+	for _, level := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if ke2400.RampTo(level, 10) == nil {
+			t.Errorf("expected error for level %g", level)
+		}
+	}
+	if events != 0 {
+		t.Errorf("invalid level must not reach instr, %d transport operations", events)
+	}
+
+	// Ramp stops at the first rejected step in fixed range
+	err = ke2400.SetFixedRangeVoltageSource(1, 10e-3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels = nil
+	err = ke2400.RampTo(10, 20)
+	if err == nil || !strings.Contains(err.Error(), "-221") {
+		t.Errorf("expected settings conflict error, got %v", err)
+	}
+	response, err = session.Query("SOUR:VOLT?")
+	if err != nil || response != formatSimNumber(2) {
+		t.Errorf("expected last accepted level 2 V, got %q, %v (writes %v)", response, err, levels)
+	}
+}
@@ -0,0 +1,202 @@
+package instruments
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	prologixDefaultTimeout = 3 * time.Second
+	prologixMaxReadTimeout = 3000 // ms, limit of ++read_tmo_ms
+)
+
+// PrologixController drives a Prologix GPIB-USB or GPIB-ETHERNET adapter. Several GPIB
+// devices can share one adapter, every PrologixTransport readdresses it under a mutex.
+type PrologixController struct {
+	// Link to the adapter: SerialTransport with TerminatorLF for GPIB-USB or
+	// SocketTransport on port 1234 for GPIB-ETHERNET
+	Link    Transport
+	Timeout time.Duration // GPIB read timeout, 3 s by default
+	mu      sync.Mutex
+	refs    int
+	address int
+}
+
+// Transport to GPIB device at primary address on the adapter bus.
+func (pc *PrologixController) Device(address int) *PrologixTransport {
+	return &PrologixTransport{Address: address, controller: pc}
+}
+
+// Open link to the adapter and switch it to controller mode, repeated calls only count references.
+func (pc *PrologixController) Open() error {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.refs > 0 {
+		pc.refs++
+		return nil
+	}
+	if pc.Timeout == 0 {
+		pc.Timeout = prologixDefaultTimeout
+	}
+	err := pc.Link.Open()
+	if err != nil {
+		return errors.Wrap(err, "couldn't open Prologix adapter link")
+	}
+	setup := []string{
+		"++savecfg 0",
+		"++mode 1",
+		"++auto 0",
+		"++eoi 1",
+		"++eos 3",
+		fmt.Sprintf("++read_tmo_ms %d", min(int(pc.Timeout/time.Millisecond), prologixMaxReadTimeout)),
+	}
+	for _, cmd := range setup {
+		err = pc.Link.Write([]byte(cmd))
+		if err != nil {
+			pc.Link.Close()
+			return errors.Wrapf(err, "Prologix adapter setup \"%s\" failed", cmd)
+		}
+	}
+	pc.refs = 1
+	pc.address = -1
+	return nil
+}
+
+// Close link to the adapter when the last device is closed.
+func (pc *PrologixController) Close() error {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.refs == 0 {
+		return nil
+	}
+	pc.refs--
+	if pc.refs > 0 {
+		return nil
+	}
+	return pc.Link.Close()
+}
+
+// Address device and send adapter command, must be called with mu held.
+func (pc *PrologixController) command(address int, cmd string) error {
+
+	if pc.refs == 0 {
+		return fmt.Errorf("Prologix adapter is not open")
+	}
+	if pc.address != address {
+		err := pc.Link.Write([]byte("++addr " + strconv.Itoa(address)))
+		if err != nil {
+			pc.address = -1
+			return err
+		}
+		pc.address = address
+	}
+	if cmd == "" {
+		return nil
+	}
+	return pc.Link.Write([]byte(cmd))
+}
+
+// PrologixTransport implements Transport to one GPIB device behind a PrologixController.
+type PrologixTransport struct {
+	Address    int // GPIB primary address
+	controller *PrologixController
+}
+
+func (pt *PrologixTransport) Open() error {
+	return pt.controller.Open()
+}
+
+// Write data to device, CR, LF, ESC and '+' are escaped so the adapter passes them through.
+func (pt *PrologixTransport) Write(data []byte) error {
+
+	escaped := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch b {
+		case '\r', '\n', 0x1B, '+':
+			escaped = append(escaped, 0x1B)
+		}
+		escaped = append(escaped, b)
+	}
+
+	pc := pt.controller
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	err := pc.command(pt.Address, "")
+	if err != nil {
+		return err
+	}
+	return pc.Link.Write(escaped)
+}
+
+// Address device to talk and read response until EOI.
+func (pt *PrologixTransport) Read() ([]byte, error) {
+
+	pc := pt.controller
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	err := pc.command(pt.Address, "++read eoi")
+	if err != nil {
+		return nil, err
+	}
+	return pc.Link.Read()
+}
+
+func (pt *PrologixTransport) Close() error {
+	return pt.controller.Close()
+}
+
+// Selected device clear (++clr).
+func (pt *PrologixTransport) Clear() error {
+	return pt.adapterCommand("++clr")
+}
+
+// Group execute trigger for the device (++trg).
+func (pt *PrologixTransport) Trigger() error {
+	return pt.adapterCommand("++trg")
+}
+
+// Return device to local state (++loc).
+func (pt *PrologixTransport) Local() error {
+	return pt.adapterCommand("++loc")
+}
+
+// Serial poll of the device (++spoll).
+func (pt *PrologixTransport) ReadSTB() (byte, error) {
+
+	pc := pt.controller
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	err := pc.command(pt.Address, "++spoll")
+	if err != nil {
+		return 0, err
+	}
+	response, err := pc.Link.Read()
+	if err != nil {
+		return 0, err
+	}
+	stb, err := strconv.ParseUint(string(response), 10, 8)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid serial poll response \"%s\"", response)
+	}
+	return byte(stb), nil
+}
+
+func (pt *PrologixTransport) adapterCommand(cmd string) error {
+
+	pc := pt.controller
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	return pc.command(pt.Address, cmd)
+}
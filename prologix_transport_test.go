@@ -0,0 +1,103 @@
+package instruments
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake Prologix GPIB-ETHERNET adapter with SCPI responders on several GPIB addresses.
+func startPrologixServer(t *testing.T, devices map[int]map[string]string) string {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		address := 0
+		output := make(map[int]string)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "++addr "):
+				fmt.Sscanf(line, "++addr %d", &address)
+			case line == "++read eoi":
+				conn.Write([]byte(output[address] + "\n"))
+				output[address] = ""
+			case strings.HasPrefix(line, "++"):
+			default:
+				// Unescape data for the device
+				var cmd strings.Builder
+				for i := 0; i < len(line); i++ {
+					if line[i] == 0x1B && i+1 < len(line) {
+						i++
+					}
+					cmd.WriteByte(line[i])
+				}
+				if response, ok := devices[address][cmd.String()]; ok {
+					output[address] = response
+				}
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestPrologixTransport(t *testing.T) {
+
+	addr := startPrologixServer(t, map[int]map[string]string{
+		24: {"*IDN?": "KEITHLEY INSTRUMENTS INC.,MODEL 2400,1111111,C30", "SOUR:VOLT +1.5;:SOUR:VOLT?": "+1.500000E+00"},
+		25: {"*IDN?": "KEITHLEY INSTRUMENTS INC.,MODEL 2410,2222222,C30", "SOUR:VOLT +1.5;:SOUR:VOLT?": "+2.500000E+00"},
+	})
+	controller := &PrologixController{Link: &SocketTransport{Address: addr, Timeout: time.Second}}
+
+	sessions := []*Session{{Transport: controller.Device(24)}, {Transport: controller.Device(25)}}
+	serials := []string{"1111111", "2222222"}
+	voltages := []string{"+1.500000E+00", "+2.500000E+00"}
+	for i, session := range sessions {
+		err := session.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		if session.GetInfo().Serial != serials[i] {
+			t.Errorf("unexpected serial %q on address %d", session.GetInfo().Serial, 24+i)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session *Session) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				response, err := session.Query("SOUR:VOLT +1.5;:SOUR:VOLT?")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if response != voltages[i] {
+					t.Errorf("response %q of address %d is mixed up", response, 24+i)
+					return
+				}
+			}
+		}(i, session)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,196 @@
+package instruments
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultReconnectAttempts = 5
+	defaultReconnectDelay    = 500 * time.Millisecond
+	defaultReconnectMaxDelay = 30 * time.Second
+)
+
+// ReconnectPolicy makes Session reopen its transport when connection to instr is lost.
+// The call which hit the failure still returns its error (instr state after reconnect is
+// unknown), the following calls go to the restored session.
+type ReconnectPolicy struct {
+	MaxAttempts  int           // attempts per failure, 5 by default, negative for unlimited
+	InitialDelay time.Duration // delay before the second attempt, 500 ms by default
+	MaxDelay     time.Duration // delay limit, 30 s by default
+	Multiplier   float64       // delay growth between attempts, 2 by default
+	// Called after transport is reopened and instr identity is verified, e.g. to re-run
+	// driver Init. Session calls of the hook must use ctx it is given.
+	Reinit func(ctx context.Context, s *Session) error
+	// Called on every reconnect event, e.g. to log them
+	OnEvent func(event ReconnectEvent)
+}
+
+// ReconnectEventKind is a stage of reconnect.
+type ReconnectEventKind int
+
+const (
+	ReconnectLost     ReconnectEventKind = iota // connection lost, Err is the failure
+	ReconnectAttempt                            // attempt to reopen the session is started
+	ReconnectFailed                             // attempt failed with Err
+	ReconnectRestored                           // session is restored
+	ReconnectGaveUp                             // no attempts left, Err is the last failure
+)
+
+func (kind ReconnectEventKind) String() string {
+
+	switch kind {
+	case ReconnectLost:
+		return "connection lost"
+	case ReconnectAttempt:
+		return "reconnect attempt"
+	case ReconnectFailed:
+		return "reconnect failed"
+	case ReconnectRestored:
+		return "connection restored"
+	case ReconnectGaveUp:
+		return "reconnect gave up"
+	}
+	return fmt.Sprintf("ReconnectEventKind(%d)", int(kind))
+}
+
+// ReconnectEvent is reported to ReconnectPolicy.OnEvent.
+type ReconnectEvent struct {
+	Kind     ReconnectEventKind
+	Identity Identity // identity of the session instr
+	Attempt  int
+	Delay    time.Duration // delay before the attempt
+	Err      error
+}
+
+// ErrIdentityChanged is returned when a reconnected instr reports different identity.
+var ErrIdentityChanged = errors.New("instr identity changed after reconnect")
+
+// Check if err means that connection to instr is lost, not just an instr or timeout error.
+func isConnectionLost(err error) bool {
+
+	if err == nil || isTimeout(err) {
+		return false
+	}
+	var lost interface{ ConnectionLost() bool }
+	if errors.As(err, &lost) {
+		return lost.ConnectionLost()
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// Reopen transport by the policy, caller holds the session lock.
+func (s *Session) reconnect(ctx context.Context, cause error) error {
+
+	policy := s.Reconnect
+	s.reconnecting = true
+	defer func() { s.reconnecting = false }()
+
+	// Calls of Reinit hook belong to the call in progress
+	ctx = context.WithValue(ctx, sessionLockKey{}, newSessionLock(s))
+
+	s.mu.Lock()
+	expected := s.info
+	s.mu.Unlock()
+	emit := func(event ReconnectEvent) {
+		event.Identity = expected
+		if policy.OnEvent != nil {
+			policy.OnEvent(event)
+		}
+	}
+	emit(ReconnectEvent{Kind: ReconnectLost, Err: cause})
+
+	attempts := policy.MaxAttempts
+	if attempts == 0 {
+		attempts = defaultReconnectAttempts
+	}
+	delay := time.Duration(0)
+	err := cause
+	for attempt := 1; attempts < 0 || attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			delay = policy.nextDelay(delay)
+			if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+				err = sleepErr
+				break
+			}
+		}
+		emit(ReconnectEvent{Kind: ReconnectAttempt, Attempt: attempt, Delay: delay})
+		err = s.reopen(ctx, expected)
+		if err == nil {
+			emit(ReconnectEvent{Kind: ReconnectRestored, Attempt: attempt})
+			return nil
+		}
+		emit(ReconnectEvent{Kind: ReconnectFailed, Attempt: attempt, Delay: delay, Err: err})
+		if errors.Is(err, ErrIdentityChanged) {
+			// Commands must not reach another instr
+			s.ioClose()
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	emit(ReconnectEvent{Kind: ReconnectGaveUp, Err: err})
+	return errors.Wrap(err, "reconnect failed")
+}
+
+// Reopen transport, verify instr identity and run Reinit hook.
+func (s *Session) reopen(ctx context.Context, expected Identity) error {
+
+	s.ioClose()
+	err := s.ioOpen()
+	if err != nil {
+		return err
+	}
+	response, err := s.query(ctx, "*IDN?")
+	if err != nil {
+		return err
+	}
+	id, err := ParseIdentity(response)
+	if err != nil {
+		return err
+	}
+	if id.Manufacturer != expected.Manufacturer || id.Model != expected.Model || id.Serial != expected.Serial {
+		return errors.Wrapf(ErrIdentityChanged, "expected %s %s %s, got %s %s %s",
+			expected.Manufacturer, expected.Model, expected.Serial, id.Manufacturer, id.Model, id.Serial)
+	}
+	if s.Reconnect.Reinit != nil {
+		err = s.Reconnect.Reinit(ctx, s)
+		if err != nil {
+			return errors.Wrap(err, "re-initialization failed")
+		}
+	}
+	return nil
+}
+
+func (policy *ReconnectPolicy) nextDelay(delay time.Duration) time.Duration {
+
+	if delay == 0 {
+		delay = policy.InitialDelay
+		if delay == 0 {
+			delay = defaultReconnectDelay
+		}
+		return delay
+	}
+	multiplier := policy.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	maxDelay := policy.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = defaultReconnectMaxDelay
+	}
+	return min(time.Duration(float64(delay)*multiplier), maxDelay)
+}
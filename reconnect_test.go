@@ -0,0 +1,155 @@
+package instruments
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// droppingServer is a raw SCPI socket stand-in able to drop all its connections.
+type droppingServer struct {
+	mu    sync.Mutex
+	idn   string
+	conns []net.Conn
+	addr  string
+}
+
+func startDroppingServer(t *testing.T, idn string) *droppingServer {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &droppingServer{idn: idn, addr: ln.Addr().String()}
+	t.Cleanup(func() {
+		ln.Close()
+		server.drop()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch strings.TrimSpace(line) {
+					case "*IDN?":
+						server.mu.Lock()
+						conn.Write([]byte(server.idn + "\n"))
+						server.mu.Unlock()
+					case "SYST:ERR?":
+						conn.Write([]byte("+0,\"No error\"\n"))
+					case "MEAS?":
+						conn.Write([]byte("+1.0E+00\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return server
+}
+
+func (server *droppingServer) drop() {
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.conns = nil
+}
+
+func (server *droppingServer) setIdentity(idn string) {
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.idn = idn
+}
+
+func TestSessionReconnect(t *testing.T) {
+
+	server := startDroppingServer(t, "Agilent Technologies,34980A,MY44001234,2.41-2.41-2.41-2.41")
+	var events []ReconnectEventKind
+	reinits := 0
+	session := Session{
+		Transport: &SocketTransport{Address: server.addr, Timeout: time.Second},
+		Reconnect: &ReconnectPolicy{
+			InitialDelay: time.Millisecond,
+			Reinit: func(ctx context.Context, s *Session) error {
+				reinits++
+				_, err := s.QueryContext(ctx, "MEAS?")
+				return err
+			},
+			OnEvent: func(event ReconnectEvent) { events = append(events, event.Kind) },
+		},
+	}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.SetErrorQuery("SYST:ERR?")
+
+	server.drop()
+	_, err = session.Query("MEAS?")
+	if err == nil {
+		t.Fatal("the call which hit connection loss must fail")
+	}
+	expected := []ReconnectEventKind{ReconnectLost, ReconnectAttempt, ReconnectRestored}
+	if len(events) != len(expected) || events[0] != expected[0] || events[2] != expected[2] || reinits != 1 {
+		t.Errorf("unexpected events %v, re-initializations %d", events, reinits)
+	}
+	response, err := session.Query("MEAS?")
+	if err != nil || response != "+1.0E+00" {
+		t.Errorf("session is not restored: %q, %v", response, err)
+	}
+
+	// Another instr behind the address
+	events = nil
+	server.setIdentity("Agilent Technologies,34980A,MY44009999,2.41-2.41-2.41-2.41")
+	server.drop()
+	_, err = session.Query("MEAS?")
+	if err == nil || !strings.Contains(err.Error(), "identity changed") {
+		t.Errorf("expected identity error, got %v", err)
+	}
+	if len(events) == 0 || events[len(events)-1] != ReconnectGaveUp {
+		t.Errorf("unexpected events %v", events)
+	}
+	_, err = session.Query("MEAS?")
+	if err == nil {
+		t.Error("session to another instr must stay closed")
+	}
+}
+
+func TestReconnectPolicyDelay(t *testing.T) {
+
+	policy := ReconnectPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	var delays []time.Duration
+	delay := time.Duration(0)
+	for i := 0; i < 4; i++ {
+		delay = policy.nextDelay(delay)
+		delays = append(delays, delay)
+	}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Errorf("unexpected delays %v", delays)
+			break
+		}
+	}
+}
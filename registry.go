@@ -0,0 +1,86 @@
+package instruments
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownInstrument is returned by OpenInstrument for instr without registered driver.
+var ErrUnknownInstrument = errors.New("no driver registered for instrument")
+
+// Driver is an initialized instrument driver returned by OpenInstrument, e.g. *Keithley2400.
+type Driver interface {
+	// Close the session of the driver
+	Close() error
+}
+
+// DriverInfo describes a driver: *IDN? manufacturer and model patterns it supports and
+// constructor initializing it over an identified session.
+type DriverInfo struct {
+	Name         string
+	Manufacturer *regexp.Regexp
+	Model        *regexp.Regexp
+	New          func(instr Instrument) (Driver, error)
+}
+
+func (d *DriverInfo) matches(id Identity) bool {
+	return d.Manufacturer.MatchString(id.Manufacturer) && d.Model.MatchString(id.Model)
+}
+
+var driverRegistry struct {
+	mu      sync.RWMutex
+	drivers []*DriverInfo
+}
+
+// Register driver, drivers registered later take precedence.
+func RegisterDriver(driver DriverInfo) {
+
+	driverRegistry.mu.Lock()
+	defer driverRegistry.mu.Unlock()
+	driverRegistry.drivers = append([]*DriverInfo{&driver}, driverRegistry.drivers...)
+}
+
+// Find driver supporting instr with identity id.
+func LookupDriver(id Identity) (*DriverInfo, bool) {
+
+	driverRegistry.mu.RLock()
+	defer driverRegistry.mu.RUnlock()
+	for _, driver := range driverRegistry.drivers {
+		if driver.matches(id) {
+			return driver, true
+		}
+	}
+	return nil, false
+}
+
+// Open resource, identify instr and return its initialized driver.
+func OpenInstrument(resource string) (Driver, error) {
+
+	session, err := Open(resource)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := NewDriver(session)
+	if err != nil {
+		session.Close()
+		return nil, errors.Wrapf(err, "couldn't open \"%s\"", resource)
+	}
+	return driver, nil
+}
+
+// Initialize driver for instr of an identified session.
+func NewDriver(session *Session) (Driver, error) {
+
+	id := session.GetInfo()
+	driver, ok := LookupDriver(id)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownInstrument, "%s %s", id.Manufacturer, id.Model)
+	}
+	instrDriver, err := driver.New(session)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s driver initialization failed", driver.Name)
+	}
+	return instrDriver, nil
+}
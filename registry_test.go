@@ -0,0 +1,87 @@
+package instruments
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLookupDriver(t *testing.T) {
+
+	drivers := map[string]Identity{
+		"Agilent 34980A": {Manufacturer: "Agilent Technologies", Model: "34980A"},
+		"Keithley 2400":  {Manufacturer: "KEITHLEY INSTRUMENTS INC.", Model: "MODEL 2440"},
+	}
+	for name, id := range drivers {
+		driver, ok := LookupDriver(id)
+		if !ok || driver.Name != name {
+			t.Errorf("%s %s: expected %s driver, got %v", id.Manufacturer, id.Model, name, driver)
+		}
+	}
+	for _, id := range []Identity{
+		{Manufacturer: "Keysight Technologies", Model: "34970A"},
+		{Manufacturer: "KEITHLEY INSTRUMENTS INC.", Model: "MODEL 2450"},
+		{Manufacturer: "Rigol", Model: "2400"},
+	} {
+		if driver, ok := LookupDriver(id); ok {
+			t.Errorf("%s %s: unexpected driver %s", id.Manufacturer, id.Model, driver.Name)
+		}
+	}
+}
+
+func TestNewDriver(t *testing.T) {
+
+	session := &Session{Transport: NewAgilent34980ASimulator(2)}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := NewDriver(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw, ok := driver.(*Agilent34980A)
+	if !ok {
+		t.Fatalf("expected *Agilent34980A, got %T", driver)
+	}
+	_, err = sw.PinsToRelays([]int{1064})
+	if err != nil {
+		t.Errorf("pins of both modules must be mapped: %v", err)
+	}
+	driver.Close()
+
+	sim := NewKeithley2400Simulator(OpenCircuit{})
+	sim.Model = "2410"
+	session = &Session{Transport: sim}
+	err = session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err = NewDriver(session)
+	if _, ok := driver.(*Keithley2400); err != nil || !ok {
+		t.Errorf("expected *Keithley2400, got %T, %v", driver, err)
+	}
+
+	// Mainframe without matrix modules
+	session = &Session{Transport: NewAgilent34980ASimulator(0)}
+	err = session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewDriver(session)
+	if err == nil {
+		t.Error("expected error for 34980A without 34932A modules")
+	}
+}
+
+func TestOpenUnknownInstrument(t *testing.T) {
+
+	addr := startSocketServer(t, map[string]string{
+		"*IDN?": "Rigol Technologies,DS1054Z,DS1ZA000000001,00.04.04",
+	})
+	host, port, _ := strings.Cut(addr, ":")
+	_, err := OpenInstrument("TCPIP0::" + host + "::" + port + "::SOCKET")
+	if !errors.Is(err, ErrUnknownInstrument) {
+		t.Errorf("expected ErrUnknownInstrument, got %v", err)
+	}
+}
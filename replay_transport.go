@@ -0,0 +1,184 @@
+package instruments
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RecordingTransport passes I/O to Transport and writes it to Tracer as a transcript,
+// e.g. to replay a bench session later with ReplayTransport.
+type RecordingTransport struct {
+	Transport Transport
+	Tracer    *FileTracer
+}
+
+func (rt *RecordingTransport) Open() error {
+	return rt.record(IOOpen, nil, func() ([]byte, error) { return nil, rt.Transport.Open() })
+}
+
+func (rt *RecordingTransport) Write(data []byte) error {
+	return rt.record(IOWrite, data, func() ([]byte, error) { return nil, rt.Transport.Write(data) })
+}
+
+func (rt *RecordingTransport) Read() ([]byte, error) {
+
+	var data []byte
+	err := rt.record(IORead, nil, func() ([]byte, error) {
+		var err error
+		data, err = rt.Transport.Read()
+		return data, err
+	})
+	return data, err
+}
+
+func (rt *RecordingTransport) Close() error {
+	return rt.record(IOClose, nil, func() ([]byte, error) { return nil, rt.Transport.Close() })
+}
+
+// Bound I/O of the recorded transport if it supports deadlines.
+func (rt *RecordingTransport) SetDeadline(t time.Time) error {
+
+	if ds, ok := rt.Transport.(DeadlineSetter); ok {
+		return ds.SetDeadline(t)
+	}
+	return nil
+}
+
+// Device clear of the recorded transport if it supports it.
+func (rt *RecordingTransport) Clear() error {
+
+	if clearer, ok := rt.Transport.(Clearer); ok {
+		return clearer.Clear()
+	}
+	return nil
+}
+
+func (rt *RecordingTransport) record(op IOOp, data []byte, run func() ([]byte, error)) error {
+
+	start := time.Now()
+	read, err := run()
+	event := IOEvent{Time: start, Op: op, Data: data, Duration: time.Since(start), Err: err}
+	if op == IORead {
+		event.Data = read
+	}
+	rt.Tracer.ObserveIO(event)
+	return err
+}
+
+// ReplayMismatchError is returned by ReplayTransport when the session deviates from transcript.
+type ReplayMismatchError struct {
+	Index    int      // index of expected event in transcript (open and close excluded)
+	Expected *IOEvent // nil if transcript is exhausted
+	Op       IOOp
+	Data     []byte
+}
+
+func (e *ReplayMismatchError) Error() string {
+
+	if e.Expected == nil {
+		return fmt.Sprintf("replay mismatch at event %d: transcript is exhausted, got %s %q", e.Index, e.Op, e.Data)
+	}
+	if e.Op == IORead {
+		return fmt.Sprintf("replay mismatch at event %d: expected %s %q, got READ", e.Index, e.Expected.Op, e.Expected.Data)
+	}
+	return fmt.Sprintf("replay mismatch at event %d: expected %s %q, got %s %q",
+		e.Index, e.Expected.Op, e.Expected.Data, e.Op, e.Data)
+}
+
+// ReplayTransport serves responses from a transcript: every write must match the next
+// recorded write and every read returns the next recorded read. Open and close aren't
+// checked. After the first mismatch all operations fail.
+type ReplayTransport struct {
+	mu       sync.Mutex
+	events   []IOEvent
+	next     int
+	mismatch error
+}
+
+// Load transcript written by FileTracer or RecordingTransport.
+func NewReplayTransport(path string) (*ReplayTransport, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open transcript")
+	}
+	defer file.Close()
+	events, err := ReadTranscript(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayTransportFromEvents(events), nil
+}
+
+func NewReplayTransportFromEvents(events []IOEvent) *ReplayTransport {
+
+	rt := &ReplayTransport{}
+	for _, event := range events {
+		if event.Op == IOWrite || event.Op == IORead {
+			rt.events = append(rt.events, event)
+		}
+	}
+	return rt
+}
+
+func (rt *ReplayTransport) Open() error {
+	return nil
+}
+
+func (rt *ReplayTransport) Write(data []byte) error {
+
+	_, err := rt.take(IOWrite, data)
+	return err
+}
+
+func (rt *ReplayTransport) Read() ([]byte, error) {
+	return rt.take(IORead, nil)
+}
+
+func (rt *ReplayTransport) Close() error {
+	return nil
+}
+
+// Check that the whole transcript is replayed.
+func (rt *ReplayTransport) Verify() error {
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.mismatch != nil {
+		return rt.mismatch
+	}
+	if rt.next < len(rt.events) {
+		event := rt.events[rt.next]
+		return fmt.Errorf("transcript is not replayed completely: %d events left, next is %s %q",
+			len(rt.events)-rt.next, event.Op, event.Data)
+	}
+	return nil
+}
+
+func (rt *ReplayTransport) take(op IOOp, data []byte) ([]byte, error) {
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.mismatch != nil {
+		return nil, rt.mismatch
+	}
+	if rt.next >= len(rt.events) {
+		rt.mismatch = &ReplayMismatchError{Index: rt.next, Op: op, Data: data}
+		return nil, rt.mismatch
+	}
+	event := rt.events[rt.next]
+	if event.Op != op || op == IOWrite && string(event.Data) != string(data) {
+		rt.mismatch = &ReplayMismatchError{Index: rt.next, Expected: &event, Op: op, Data: data}
+		return nil, rt.mismatch
+	}
+	rt.next++
+	if event.Err != nil {
+		return nil, event.Err
+	}
+	return event.Data, nil
+}
@@ -0,0 +1,134 @@
+package instruments
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// Run the same bench sequence on Keithley 2400 and Agilent 34980A sessions.
+func runBenchSequence(ke2400Session, swSession *Session) (current, voltage float64, states []bool, err error) {
+
+	ke2400 := Keithley2400{}
+	err = ke2400.Init(ke2400Session)
+	if err != nil {
+		return
+	}
+	err = ke2400.SetFixedRangeVoltageSource(2, 0.01, 1, false)
+	if err != nil {
+		return
+	}
+	err = ke2400Session.Write("OUTP ON")
+	if err != nil {
+		return
+	}
+	current, voltage, err = ke2400.ReadSrcData()
+	if err != nil {
+		return
+	}
+
+	sw := Agilent34980A{}
+	err = sw.Init(swSession, 32)
+	if err != nil {
+		return
+	}
+	err = sw.SetCommutation([]int{1001, 2005}, true)
+	if err != nil {
+		return
+	}
+	states, err = sw.GetCommutation([]int{1001, 2005, 3010})
+	return
+}
+
+func TestRecordReplay(t *testing.T) {
+
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "ke2400.trace"), filepath.Join(dir, "sw.trace")}
+	transports := []Transport{NewKeithley2400Simulator(Resistor{Resistance: 1e3}), NewAgilent34980ASimulator(1)}
+	sessions := make([]*Session, 2)
+	tracers := make([]*FileTracer, 2)
+	for i := range paths {
+		tracer, err := NewFileTracer(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracers[i] = tracer
+		sessions[i] = &Session{Transport: &RecordingTransport{Transport: transports[i], Tracer: tracer}}
+		err = sessions[i].Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, voltage, states, err := runBenchSequence(sessions[0], sessions[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range sessions {
+		sessions[i].Close()
+		tracers[i].Close()
+	}
+
+	// Replay
+	replays := make([]*ReplayTransport, 2)
+	for i := range paths {
+		replays[i], err = NewReplayTransport(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[i] = &Session{Transport: replays[i]}
+		err = sessions[i].Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	replayedCurrent, replayedVoltage, replayedStates, err := runBenchSequence(sessions[0], sessions[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayedCurrent != current || replayedVoltage != voltage || len(replayedStates) != 3 ||
+		replayedStates[0] != states[0] || replayedStates[1] != states[1] || replayedStates[2] != states[2] {
+		t.Errorf("replay differs from recording: %g %g %v vs %g %g %v",
+			replayedCurrent, replayedVoltage, replayedStates, current, voltage, states)
+	}
+	for i := range replays {
+		err = replays[i].Verify()
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Command mismatch fails loudly and for good
+	replay, err := NewReplayTransport(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := Session{Transport: replay}
+	err = session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ke2400 := Keithley2400{}
+	err = ke2400.Init(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ke2400.SetFixedRangeVoltageSource(5, 0.01, 1, false)
+	var mismatch *ReplayMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Expected == nil {
+		t.Fatalf("expected replay mismatch, got %v", err)
+	}
+	_, err = session.Query("*IDN?")
+	if !errors.As(err, &mismatch) {
+		t.Errorf("replay must keep failing after mismatch, got %v", err)
+	}
+	if replay.Verify() == nil {
+		t.Error("Verify must report the mismatch")
+	}
+
+	// Ordering drift: read where a write is recorded
+	replay = NewReplayTransportFromEvents([]IOEvent{{Op: IOWrite, Data: []byte("*IDN?")}})
+	_, err = replay.Read()
+	if !errors.As(err, &mismatch) {
+		t.Errorf("expected ordering mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package instruments
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Resource is a parsed VISA resource string.
+type Resource interface {
+	// Canonical VISA resource string
+	String() string
+	// Transport able to reach the resource
+	transport() Transport
+}
+
+// TCPIP[board]::host::port::SOCKET
+type SocketResource struct {
+	Board int
+	Host  string
+	Port  int
+}
+
+func (r *SocketResource) String() string {
+	return fmt.Sprintf("TCPIP%d::%s::%d::SOCKET", r.Board, r.Host, r.Port)
+}
+
+func (r *SocketResource) transport() Transport {
+	return &SocketTransport{Address: net.JoinHostPort(r.Host, strconv.Itoa(r.Port))}
+}
+
+// TCPIP[board]::host[::device]::INSTR served over VXI-11
+type VXI11Resource struct {
+	Board  int
+	Host   string
+	Device string
+}
+
+func (r *VXI11Resource) String() string {
+	return fmt.Sprintf("TCPIP%d::%s::%s::INSTR", r.Board, r.Host, r.Device)
+}
+
+func (r *VXI11Resource) transport() Transport {
+	return &VXI11Transport{Host: r.Host, Device: r.Device}
+}
+
+// TCPIP[board]::host::hislipN[,port]::INSTR
+type HiSLIPResource struct {
+	Board      int
+	Host       string
+	SubAddress string
+	Port       int
+}
+
+func (r *HiSLIPResource) String() string {
+	if r.Port != hislipPort {
+		return fmt.Sprintf("TCPIP%d::%s::%s,%d::INSTR", r.Board, r.Host, r.SubAddress, r.Port)
+	}
+	return fmt.Sprintf("TCPIP%d::%s::%s::INSTR", r.Board, r.Host, r.SubAddress)
+}
+
+func (r *HiSLIPResource) transport() Transport {
+	return &HiSLIPTransport{Host: r.Host, SubAddress: r.SubAddress, Port: r.Port}
+}
+
+// ASRL<device>::INSTR, device is either a path (ASRL/dev/ttyUSB0) or a port number (ASRL1 is /dev/ttyS0)
+type SerialResource struct {
+	Device string
+}
+
+func (r *SerialResource) String() string {
+	return fmt.Sprintf("ASRL%s::INSTR", r.Device)
+}
+
+func (r *SerialResource) transport() Transport {
+	device := r.Device
+	if n, err := strconv.Atoi(device); err == nil {
+		device = fmt.Sprintf("/dev/ttyS%d", n-1)
+	}
+	return &SerialTransport{Device: device}
+}
+
+// GPIB[board]::primary[::secondary]::INSTR, reachable through VISA only
+type GPIBResource struct {
+	Board     int
+	Primary   int
+	Secondary int // -1 if not used
+}
+
+func (r *GPIBResource) String() string {
+	if r.Secondary >= 0 {
+		return fmt.Sprintf("GPIB%d::%d::%d::INSTR", r.Board, r.Primary, r.Secondary)
+	}
+	return fmt.Sprintf("GPIB%d::%d::INSTR", r.Board, r.Primary)
+}
+
+func (r *GPIBResource) transport() Transport {
+	return newVISATransport(r.String())
+}
+
+// Parse VISA resource string.
+func ParseResource(resource string) (Resource, error) {
+
+	fields := strings.Split(strings.TrimSpace(resource), "::")
+	for _, field := range fields {
+		if field == "" {
+			return nil, fmt.Errorf("invalid resource \"%s\": empty field", resource)
+		}
+	}
+	class := ""
+	if last := strings.ToUpper(fields[len(fields)-1]); last == "INSTR" || last == "SOCKET" {
+		class = last
+		fields = fields[0 : len(fields)-1]
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("invalid resource \"%s\": no interface type", resource)
+	}
+
+	iface := strings.ToUpper(fields[0])
+	switch {
+	case strings.HasPrefix(iface, "TCPIP"):
+		return parseTCPIPResource(resource, fields, class)
+	case strings.HasPrefix(iface, "ASRL"):
+		if class == "SOCKET" || len(fields) != 1 || len(fields[0]) == len("ASRL") {
+			return nil, fmt.Errorf("invalid resource \"%s\": expected ASRL<device>::INSTR", resource)
+		}
+		return &SerialResource{Device: fields[0][len("ASRL"):]}, nil
+	case strings.HasPrefix(iface, "GPIB"):
+		return parseGPIBResource(resource, fields, class)
+	}
+	return nil, fmt.Errorf("invalid resource \"%s\": unsupported interface type \"%s\"", resource, fields[0])
+}
+
+func parseTCPIPResource(resource string, fields []string, class string) (Resource, error) {
+
+	board, err := parseBoard(fields[0], "TCPIP")
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource \"%s\": %v", resource, err)
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid resource \"%s\": no host", resource)
+	}
+	host := fields[1]
+
+	if class == "SOCKET" {
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid resource \"%s\": expected TCPIP::host::port::SOCKET", resource)
+		}
+		port, err := strconv.Atoi(fields[2])
+		if err != nil || port <= 0 || port > 0xFFFF {
+			return nil, fmt.Errorf("invalid resource \"%s\": invalid port \"%s\"", resource, fields[2])
+		}
+		return &SocketResource{Board: board, Host: host, Port: port}, nil
+	}
+
+	switch len(fields) {
+	case 2:
+		return &VXI11Resource{Board: board, Host: host, Device: "inst0"}, nil
+	case 3:
+		device := fields[2]
+		if !strings.HasPrefix(strings.ToLower(device), "hislip") {
+			return &VXI11Resource{Board: board, Host: host, Device: device}, nil
+		}
+		port := hislipPort
+		if i := strings.Index(device, ","); i >= 0 {
+			port, err = strconv.Atoi(device[i+1:])
+			if err != nil || port <= 0 || port > 0xFFFF {
+				return nil, fmt.Errorf("invalid resource \"%s\": invalid HiSLIP port \"%s\"", resource, device[i+1:])
+			}
+			device = device[0:i]
+		}
+		return &HiSLIPResource{Board: board, Host: host, SubAddress: device, Port: port}, nil
+	}
+	return nil, fmt.Errorf("invalid resource \"%s\": expected TCPIP::host[::device]::INSTR", resource)
+}
+
+func parseGPIBResource(resource string, fields []string, class string) (Resource, error) {
+
+	board, err := parseBoard(fields[0], "GPIB")
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource \"%s\": %v", resource, err)
+	}
+	if class == "SOCKET" || len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("invalid resource \"%s\": expected GPIB::primary[::secondary]::INSTR", resource)
+	}
+	primary, err := strconv.Atoi(fields[1])
+	if err != nil || primary < 0 || primary > 30 {
+		return nil, fmt.Errorf("invalid resource \"%s\": invalid GPIB primary address \"%s\"", resource, fields[1])
+	}
+	secondary := -1
+	if len(fields) == 3 {
+		secondary, err = strconv.Atoi(fields[2])
+		if err != nil || secondary < 0 || secondary > 30 {
+			return nil, fmt.Errorf("invalid resource \"%s\": invalid GPIB secondary address \"%s\"", resource, fields[2])
+		}
+	}
+	return &GPIBResource{Board: board, Primary: primary, Secondary: secondary}, nil
+}
+
+func parseBoard(field, iface string) (int, error) {
+
+	suffix := field[len(iface):]
+	if suffix == "" {
+		return 0, nil
+	}
+	board, err := strconv.Atoi(suffix)
+	if err != nil || board < 0 {
+		return 0, fmt.Errorf("invalid board number \"%s\"", suffix)
+	}
+	return board, nil
+}
+
+// Open resource with a backend picked by its type and identify instr with *IDN?.
+// GPIB resources are opened through the default VISA resource manager, without VISA library
+// (build tag "visa") Open fails with ErrVISAUnavailable.
+func Open(resource string) (*Session, error) {
+
+	res, err := ParseResource(resource)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{Transport: res.transport()}
+	err = session.Init()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
@@ -0,0 +1,85 @@
+package instruments
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseResource(t *testing.T) {
+
+	tests := []struct {
+		resource  string
+		expected  Resource
+		canonical string
+	}{
+		{"TCPIP0::192.168.0.10::5025::SOCKET", &SocketResource{0, "192.168.0.10", 5025}, "TCPIP0::192.168.0.10::5025::SOCKET"},
+		{"tcpip::bench-dmm::5025::socket", &SocketResource{0, "bench-dmm", 5025}, "TCPIP0::bench-dmm::5025::SOCKET"},
+		{"TCPIP0::192.168.0.10::INSTR", &VXI11Resource{0, "192.168.0.10", "inst0"}, "TCPIP0::192.168.0.10::inst0::INSTR"},
+		{"TCPIP1::10.0.0.2::gpib0,24::INSTR", &VXI11Resource{1, "10.0.0.2", "gpib0,24"}, "TCPIP1::10.0.0.2::gpib0,24::INSTR"},
+		{"TCPIP::10.0.0.2::hislip0", &HiSLIPResource{0, "10.0.0.2", "hislip0", 4880}, "TCPIP0::10.0.0.2::hislip0::INSTR"},
+		{"TCPIP0::10.0.0.2::hislip1,4881::INSTR", &HiSLIPResource{0, "10.0.0.2", "hislip1", 4881}, "TCPIP0::10.0.0.2::hislip1,4881::INSTR"},
+		{"ASRL/dev/ttyUSB0::INSTR", &SerialResource{"/dev/ttyUSB0"}, "ASRL/dev/ttyUSB0::INSTR"},
+		{"ASRL1::INSTR", &SerialResource{"1"}, "ASRL1::INSTR"},
+		{"GPIB0::24::INSTR", &GPIBResource{0, 24, -1}, "GPIB0::24::INSTR"},
+		{"GPIB1::5::2::INSTR", &GPIBResource{1, 5, 2}, "GPIB1::5::2::INSTR"},
+	}
+	for _, test := range tests {
+		res, err := ParseResource(test.resource)
+		if err != nil {
+			t.Errorf("%s: %v", test.resource, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("%s: parsed as %#v", test.resource, res)
+		}
+		if res.String() != test.canonical {
+			t.Errorf("%s: canonical form %s", test.resource, res.String())
+		}
+	}
+
+	malformed := map[string]string{
+		"":                            "empty field",
+		"TCPIP0::::INSTR":             "empty field",
+		"TCPIP0::host::port::SOCKET":  "invalid port",
+		"TCPIP0::host::SOCKET":        "expected TCPIP::host::port::SOCKET",
+		"TCPIPx::host::INSTR":         "invalid board number",
+		"TCPIP0::host::hislip0,x":     "invalid HiSLIP port",
+		"TCPIP0::host::a::b::INSTR":   "expected TCPIP::host[::device]::INSTR",
+		"GPIB0::31::INSTR":            "invalid GPIB primary address",
+		"GPIB0::INSTR":                "expected GPIB::primary[::secondary]::INSTR",
+		"ASRL::INSTR":                 "expected ASRL<device>::INSTR",
+		"USB0::0x0957::0x0607::INSTR": "unsupported interface type",
+		"INSTR":                       "no interface type",
+	}
+	for resource, msg := range malformed {
+		_, err := ParseResource(resource)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected error containing %q, got %v", resource, msg, err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+
+	addr := startSocketServer(t, map[string]string{
+		"*IDN?": "Agilent Technologies,34980A,MY44001234,2.41-2.41-2.41-2.41",
+	})
+	host, port, _ := strings.Cut(addr, ":")
+	session, err := Open("TCPIP0::" + host + "::" + port + "::SOCKET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if _, ok := session.Transport.(*SocketTransport); !ok {
+		t.Errorf("unexpected transport %T", session.Transport)
+	}
+	if session.GetInfo().Model != "34980A" {
+		t.Errorf("unexpected model %q", session.GetInfo().Model)
+	}
+
+	_, err = Open("TCPIP0::" + host + "::0::SOCKET")
+	if err == nil {
+		t.Error("malformed resource must not be opened")
+	}
+}
@@ -0,0 +1,103 @@
+package instruments
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxErrorQueue bounds draining of instr error queue, instr repeating an error forever
+// must not hang the session.
+const maxErrorQueue = 100
+
+// Classes of SCPI errors by standard code ranges (SCPI-99 21.8), errors.Is(err, ErrSCPIExecution)
+// is true for any execution error in err.
+var (
+	ErrSCPICommand   = errors.New("SCPI command error")
+	ErrSCPIExecution = errors.New("SCPI execution error")
+	ErrSCPIDevice    = errors.New("SCPI device-specific error")
+	ErrSCPIQuery     = errors.New("SCPI query error")
+	ErrSCPIPowerOn   = errors.New("SCPI power on event")
+	ErrSCPIUser      = errors.New("SCPI user request event")
+	ErrSCPIControl   = errors.New("SCPI request control event")
+	ErrSCPIOperation = errors.New("SCPI operation complete event")
+)
+
+// SCPIError is an entry of instr error queue, Command is the command after which it was read.
+type SCPIError struct {
+	Code    int
+	Message string
+	Command string
+}
+
+func (e *SCPIError) Error() string {
+	return fmt.Sprintf("%+d,\"%s\"", e.Code, e.Message)
+}
+
+// Class of the error, one of ErrSCPI* values. Positive codes are device-specific.
+func (e *SCPIError) Class() error {
+
+	switch {
+	case e.Code <= -100 && e.Code > -200:
+		return ErrSCPICommand
+	case e.Code <= -200 && e.Code > -300:
+		return ErrSCPIExecution
+	case e.Code <= -300 && e.Code > -400, e.Code > 0:
+		return ErrSCPIDevice
+	case e.Code <= -400 && e.Code > -500:
+		return ErrSCPIQuery
+	case e.Code <= -500 && e.Code > -600:
+		return ErrSCPIPowerOn
+	case e.Code <= -600 && e.Code > -700:
+		return ErrSCPIUser
+	case e.Code <= -700 && e.Code > -800:
+		return ErrSCPIControl
+	case e.Code <= -800 && e.Code > -900:
+		return ErrSCPIOperation
+	}
+	return nil
+}
+
+func (e *SCPIError) Is(target error) bool {
+
+	if class := e.Class(); class != nil && class == target {
+		return true
+	}
+	t, ok := target.(*SCPIError)
+	return ok && t.Code == e.Code
+}
+
+// SCPIErrors are all entries drained from instr error queue in order of occurrence.
+type SCPIErrors []*SCPIError
+
+func (errs SCPIErrors) Error() string {
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (errs SCPIErrors) Unwrap() []error {
+
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
+
+// Parse SYST:ERR? response, e.g. `-113,"Undefined header"`.
+func parseSCPIError(response, cmd string) (*SCPIError, error) {
+
+	codeStr, message, _ := strings.Cut(response, ",")
+	code, err := strconv.Atoi(strings.TrimSpace(codeStr))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error query response \"%s\"", response)
+	}
+	message = strings.Trim(strings.TrimSpace(message), "\"")
+	return &SCPIError{Code: code, Message: message, Command: cmd}, nil
+}
@@ -0,0 +1,69 @@
+package instruments
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSCPIErrorQueue(t *testing.T) {
+
+	session := Session{Transport: NewAgilent34980ASimulator(1)}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetErrorQuery("SYST:ERR?")
+
+	err = session.Write("FOO;ROUT:CLOS (@9999);BAR")
+	var errs SCPIErrors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expected 3 queued errors, got %v", err)
+	}
+	if !errors.Is(err, ErrSCPICommand) || !errors.Is(err, ErrSCPIExecution) || errors.Is(err, ErrSCPIQuery) {
+		t.Errorf("wrong error classes of %v", err)
+	}
+	if !errors.Is(err, &SCPIError{Code: -113}) {
+		t.Errorf("expected -113 in %v", err)
+	}
+	var scpiErr *SCPIError
+	if !errors.As(err, &scpiErr) || scpiErr.Code != -113 || scpiErr.Message != "Undefined header" ||
+		scpiErr.Command != "FOO;ROUT:CLOS (@9999);BAR" {
+		t.Errorf("unexpected first error %#v", scpiErr)
+	}
+
+	// The queue is drained
+	err = session.CheckErrors()
+	if err != nil {
+		t.Errorf("expected empty error queue, got %v", err)
+	}
+}
+
+func TestSCPIErrorClass(t *testing.T) {
+
+	classes := map[int]error{
+		-101: ErrSCPICommand,
+		-222: ErrSCPIExecution,
+		-350: ErrSCPIDevice,
+		803:  ErrSCPIDevice,
+		-420: ErrSCPIQuery,
+		-500: ErrSCPIPowerOn,
+		-600: ErrSCPIUser,
+		-700: ErrSCPIControl,
+		-800: ErrSCPIOperation,
+		-50:  nil,
+	}
+	for code, class := range classes {
+		if got := (&SCPIError{Code: code}).Class(); got != class {
+			t.Errorf("%d: expected class %v, got %v", code, class, got)
+		}
+	}
+
+	_, err := parseSCPIError("garbage", "")
+	if err == nil {
+		t.Error("expected parse error")
+	}
+	scpiErr, err := parseSCPIError(" -224, \"Illegal parameter value\"", "VOLT X")
+	if err != nil || scpiErr.Code != -224 || scpiErr.Message != "Illegal parameter value" {
+		t.Errorf("unexpected parse result %#v, %v", scpiErr, err)
+	}
+}
@@ -0,0 +1,134 @@
+package instruments
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Parity int
+
+const (
+	ParityNone Parity = iota
+	ParityOdd
+	ParityEven
+)
+
+type FlowControl int
+
+const (
+	FlowNone FlowControl = iota
+	FlowXonXoff
+	FlowRtsCts
+)
+
+// Serial line terminators (Keithley 2400 :SYST:RS232:TERM CR|LF|CRLF|LFCR)
+const (
+	TerminatorCR   = "\r"
+	TerminatorLF   = "\n"
+	TerminatorCRLF = "\r\n"
+	TerminatorLFCR = "\n\r"
+)
+
+const defaultSerialTimeout = 10 * time.Second
+
+// SerialTransport implements Transport over RS-232 (ASRL/dev/ttyUSB0::INSTR) without VISA.
+type SerialTransport struct {
+	Device      string        // serial device, e.g. "/dev/ttyUSB0"
+	BaudRate    int           // 9600 by default
+	DataBits    int           // 8 by default
+	Parity      Parity        // ParityNone by default
+	StopBits    int           // 1 by default
+	FlowControl FlowControl   // FlowNone by default
+	Terminator  string        // line terminator for both directions, TerminatorCR by default
+	Timeout     time.Duration // read and write timeout, 10 s by default
+	port        *os.File
+	pending     []byte
+	deadline    ioDeadline
+}
+
+func (st *SerialTransport) Open() error {
+
+	if st.BaudRate == 0 {
+		st.BaudRate = 9600
+	}
+	if st.DataBits == 0 {
+		st.DataBits = 8
+	}
+	if st.StopBits == 0 {
+		st.StopBits = 1
+	}
+	if st.Terminator == "" {
+		st.Terminator = TerminatorCR
+	}
+	if st.Timeout == 0 {
+		st.Timeout = defaultSerialTimeout
+	}
+
+	port, err := openSerialPort(st)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't open serial port \"%s\"", st.Device)
+	}
+	st.port = port
+	st.pending = nil
+	return nil
+}
+
+func (st *SerialTransport) Write(data []byte) error {
+
+	if st.port == nil {
+		return fmt.Errorf("serial port \"%s\" is not open", st.Device)
+	}
+	err := st.deadline.apply(st.Timeout, st.port.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	_, err = st.port.Write(append(append([]byte(nil), data...), st.Terminator...))
+	return err
+}
+
+// Read line until the last character of Terminator, stray CR/LF around the line are dropped.
+func (st *SerialTransport) Read() ([]byte, error) {
+
+	if st.port == nil {
+		return nil, fmt.Errorf("serial port \"%s\" is not open", st.Device)
+	}
+	err := st.deadline.apply(st.Timeout, st.port.SetReadDeadline)
+	if err != nil {
+		return nil, err
+	}
+	terminator := []byte{st.Terminator[len(st.Terminator)-1]}
+	for {
+		msg, pending, err := readTerminated(st.port, st.pending, terminator, bufferSize)
+		st.pending = pending
+		if err != nil {
+			return nil, err
+		}
+		// Skip the remainder of a two-character terminator
+		if line := strings.Trim(string(msg), "\r\n"); len(line) > 0 {
+			return []byte(line), nil
+		}
+	}
+}
+
+func (st *SerialTransport) Close() error {
+
+	if st.port == nil {
+		return nil
+	}
+	err := st.port.Close()
+	st.port = nil
+	return err
+}
+
+// Bound I/O by t, I/O in progress is interrupted if t is in the past.
+func (st *SerialTransport) SetDeadline(t time.Time) error {
+
+	if st.port == nil {
+		return st.deadline.setLimit(t, nil)
+	}
+	return st.deadline.setLimit(t, st.port.SetDeadline)
+}
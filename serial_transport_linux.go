@@ -0,0 +1,107 @@
+package instruments
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Not exported by package syscall
+const (
+	termiosCBAUD   = 0x100f
+	termiosCRTSCTS = 0x80000000
+)
+
+var baudRates = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+}
+
+// Open serial device in non-blocking mode (so deadlines work) and switch it to raw mode.
+func openSerialPort(st *SerialTransport) (*os.File, error) {
+
+	baud, ok := baudRates[st.BaudRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", st.BaudRate)
+	}
+	port, err := os.OpenFile(st.Device, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var tio syscall.Termios
+	err = termiosIoctl(port, syscall.TCGETS, &tio)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	tio.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF | syscall.IXANY
+	tio.Oflag &^= syscall.OPOST
+	tio.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	tio.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB | termiosCRTSCTS | termiosCBAUD
+	tio.Cflag |= syscall.CREAD | syscall.CLOCAL | baud
+
+	switch st.DataBits {
+	case 7:
+		tio.Cflag |= syscall.CS7
+	case 8:
+		tio.Cflag |= syscall.CS8
+	default:
+		port.Close()
+		return nil, fmt.Errorf("unsupported number of data bits %d", st.DataBits)
+	}
+	switch st.Parity {
+	case ParityOdd:
+		tio.Cflag |= syscall.PARENB | syscall.PARODD
+	case ParityEven:
+		tio.Cflag |= syscall.PARENB
+	}
+	if st.StopBits == 2 {
+		tio.Cflag |= syscall.CSTOPB
+	}
+	switch st.FlowControl {
+	case FlowXonXoff:
+		tio.Iflag |= syscall.IXON | syscall.IXOFF
+	case FlowRtsCts:
+		tio.Cflag |= termiosCRTSCTS
+	}
+	tio.Ispeed = baud
+	tio.Ospeed = baud
+	tio.Cc[syscall.VMIN] = 1
+	tio.Cc[syscall.VTIME] = 0
+
+	err = termiosIoctl(port, syscall.TCSETS, &tio)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+func termiosIoctl(port *os.File, request uintptr, tio *syscall.Termios) error {
+
+	conn, err := port.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(tio)))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package instruments
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty returns master side of a new pseudo terminal and path of its slave side.
+func openPty(t *testing.T) (*os.File, string) {
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo terminals are not available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	var unlock int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	var ptyNum uint32
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum)))
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", ptyNum)
+}
+
+// Fake Keithley 2400 on the master side of pty, answering CR terminated lines with CR terminator.
+func fakeSerialKeithley(master *os.File, responses map[string]string, received chan<- string) {
+
+	reader := bufio.NewReader(master)
+	for {
+		line, err := reader.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		received <- cmd
+		if response, ok := responses[cmd]; ok {
+			master.Write([]byte(response + "\r"))
+		}
+	}
+}
+
+func TestSerialTransportKeithley2400(t *testing.T) {
+
+	master, slave := openPty(t)
+	received := make(chan string, 100)
+	go fakeSerialKeithley(master, map[string]string{
+		"*IDN?":     "KEITHLEY INSTRUMENTS INC.,MODEL 2400,1234567,C30   Mar 17 2006 09:29:29/A02  /K/J",
+		"SYST:ERR?": "0,\"No error\"",
+		":READ?":    "+1.000000E+00,+1.000000E-03,+9.910000E+37,+1.234000E+03,+2.150800E+04",
+	}, received)
+
+	session := Session{Transport: &SerialTransport{
+		Device:      slave,
+		BaudRate:    57600,
+		FlowControl: FlowXonXoff,
+		Terminator:  TerminatorCR,
+		Timeout:     time.Second,
+	}}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if session.GetInfo().Model != "MODEL 2400" {
+		t.Errorf("unexpected model %q", session.GetInfo().Model)
+	}
+
+	ke2400 := Keithley2400{}
+	err = ke2400.Init(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, voltage, err := ke2400.ReadSrcData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 1e-3 || voltage != 1 {
+		t.Errorf("unexpected reading %g A, %g V", current, voltage)
+	}
+
+	var commands []string
+	for len(received) > 0 {
+		commands = append(commands, <-received)
+	}
+	expected := []string{"*IDN?", "*RST", "SYST:ERR?", "FORM:ELEM VOLT,CURR,RES,TIME,STAT", "SYST:ERR?", ":READ?"}
+	if strings.Join(commands, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected command sequence %q", commands)
+	}
+
+	_, err = session.ReadBytes()
+	if err == nil {
+		t.Error("read without response must fail on timeout")
+	}
+}
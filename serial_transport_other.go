@@ -0,0 +1,13 @@
+//go:build !linux
+
+package instruments
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func openSerialPort(st *SerialTransport) (*os.File, error) {
+	return nil, fmt.Errorf("serial transport is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,467 @@
+package instruments
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Size of a single transport read.
+const bufferSize = 1024
+
+// ErrVISAUnavailable is returned for resources that need VISA library when the package is
+// built without it (build tag "visa").
+var ErrVISAUnavailable = errors.New("VISA library is not compiled in, build with -tags visa")
+
+// Transport is a raw message channel to an instrument (VISA, socket, VXI-11, ...).
+type Transport interface {
+	// Open connection to instr
+	Open() error
+	// Write one message to instr
+	Write(data []byte) error
+	// Read one response message from instr
+	Read() ([]byte, error)
+	// Close connection to instr
+	Close() error
+}
+
+// Session implements Instrument on top of any Transport. It is safe for concurrent use:
+// every call owns the transport exclusively, multi-command transactions use Lock/WithLock.
+type Session struct {
+	Transport  Transport
+	Reconnect  *ReconnectPolicy // reopen transport when connection is lost, nil disables
+	Observer   IOObserver       // notified about every transport operation, e.g. FileTracer
+	errorQuery string
+	info       Identity
+
+	mu      sync.Mutex // guards errorQuery and info
+	semOnce sync.Once
+	sem     chan struct{} // held by the current call or transaction
+
+	reconnecting bool // guarded by sem
+}
+
+var (
+	_ ContextInstrument = (*Session)(nil)
+	_ Locker            = (*Session)(nil)
+)
+
+// sessionLock is stored in the context of a transaction started by Lock. The context may
+// be shared by goroutines, their calls inside the transaction are serialized by io.
+type sessionLock struct {
+	session *Session
+	depth   atomic.Int32 // nested Lock calls, the transaction ends when it drops to zero
+	io      sync.Mutex   // held by the current call of the transaction
+}
+
+func newSessionLock(s *Session) *sessionLock {
+
+	lock := &sessionLock{session: s}
+	lock.depth.Store(1)
+	return lock
+}
+
+type sessionLockKey struct{}
+
+func (s *Session) Init() error {
+	return s.InitContext(context.Background())
+}
+
+// Open transport and identify instr, identification is bounded by ctx.
+func (s *Session) InitContext(ctx context.Context) error {
+
+	ctx, err := s.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Unlock(ctx)
+
+	err = s.ioOpen()
+	if err != nil {
+		return err
+	}
+	response, err := s.QueryContext(ctx, "*IDN?")
+	if err != nil {
+		return err
+	}
+	info, err := ParseIdentity(response)
+	if err != nil {
+		return errors.Wrap(err, "instr identification failed")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = info
+	return nil
+}
+
+// Write command to instr and read response
+func (s *Session) Query(cmd string) (string, error) {
+	return s.QueryContext(context.Background(), cmd)
+}
+
+// Write command to instr and read response, ctx bounds the whole exchange.
+func (s *Session) QueryContext(ctx context.Context, cmd string) (string, error) {
+
+	var response string
+	err := s.withContext(ctx, cmd, func() error {
+		var err error
+		response, err = s.query(ctx, cmd)
+		return err
+	})
+	return response, err
+}
+
+// Write command to instr
+func (s *Session) Write(cmd string) error {
+	return s.WriteContext(context.Background(), cmd)
+}
+
+// Write command to instr and check instr errors, ctx bounds both.
+func (s *Session) WriteContext(ctx context.Context, cmd string) error {
+
+	return s.withContext(ctx, cmd, func() error {
+		err := s.write(cmd)
+		if err != nil {
+			return err
+		}
+		instrErr := s.checkErrors(ctx, cmd)
+		if instrErr != nil {
+			context := fmt.Sprintf("an instr error occurred while writing \"%s\" command", cmd)
+			return errors.Wrap(instrErr, context)
+		}
+		return nil
+	})
+}
+
+// Write command to instr without instr error check (not recommended)
+func (s *Session) WriteWithoutCheck(cmd string) error {
+	return s.WriteWithoutCheckContext(context.Background(), cmd)
+}
+
+func (s *Session) WriteWithoutCheckContext(ctx context.Context, cmd string) error {
+
+	return s.withContext(ctx, cmd, func() error {
+		return s.write(cmd)
+	})
+}
+
+// Read raw response bytes from instr
+func (s *Session) ReadBytes() ([]byte, error) {
+	return s.ReadBytesContext(context.Background())
+}
+
+func (s *Session) ReadBytesContext(ctx context.Context) ([]byte, error) {
+
+	var bytes []byte
+	err := s.withContext(ctx, "", func() error {
+		var err error
+		bytes, err = s.ioRead()
+		if err != nil {
+			return errors.Wrap(err, "an error occurred while reading response")
+		}
+		return nil
+	})
+	return bytes, err
+}
+
+// Check instrument errors
+func (s *Session) CheckErrors() error {
+	return s.CheckErrorsContext(context.Background())
+}
+
+func (s *Session) CheckErrorsContext(ctx context.Context) error {
+
+	s.mu.Lock()
+	errorQuery := s.errorQuery
+	s.mu.Unlock()
+
+	return s.withContext(ctx, errorQuery, func() error {
+		return s.checkErrors(ctx, "")
+	})
+}
+
+func (s *Session) query(ctx context.Context, cmd string) (string, error) {
+
+	err := s.ioWrite([]byte(cmd))
+	if err != nil {
+		context := fmt.Sprintf("an error occurred while writing \"%s\" command", cmd)
+		return "", errors.Wrap(err, context)
+	}
+
+	bytes, err := s.ioRead()
+	if err != nil {
+		// Instr error queue can't be asked after cancellation
+		if ctx.Err() == nil {
+			instrErr := s.checkErrors(ctx, cmd)
+			if instrErr != nil {
+				context := fmt.Sprintf("an instr error occurred while reading response after \"%s\" command", cmd)
+				return "", errors.Wrap(instrErr, context)
+			}
+		}
+		context := fmt.Sprintf("an error occurred while reading response after \"%s\" command", cmd)
+		return "", errors.Wrap(err, context)
+	}
+	response := string(bytes)
+	if len(response) == 0 {
+		return response, fmt.Errorf("get empty response from instr after \"%s\" command", cmd)
+	}
+	if i := strings.Index(response, "\n"); i >= 0 {
+		response = response[0:i]
+	}
+	return strings.TrimRight(response, "\r"), nil
+}
+
+func (s *Session) write(cmd string) error {
+
+	err := s.ioWrite([]byte(cmd))
+	if err != nil {
+		context := fmt.Sprintf("an error occurred while writing \"%s\" command", cmd)
+		return errors.Wrap(err, context)
+	}
+	return nil
+}
+
+// Drain instr error queue, errors are attributed to cmd. A failed error query is not
+// reported, the caller has its own I/O error.
+func (s *Session) checkErrors(ctx context.Context, cmd string) error {
+
+	s.mu.Lock()
+	errorQuery := s.errorQuery
+	s.mu.Unlock()
+	if errorQuery == "" {
+		return nil
+	}
+
+	var errs SCPIErrors
+	for len(errs) < maxErrorQueue && ctx.Err() == nil {
+		err := s.write(errorQuery)
+		if err != nil {
+			break
+		}
+		bytes, err := s.ioRead()
+		if err != nil {
+			break
+		}
+		response := strings.TrimRight(strings.SplitN(string(bytes), "\n", 2)[0], "\r")
+		scpiErr, err := parseSCPIError(response, cmd)
+		if err != nil || scpiErr.Code == 0 {
+			break
+		}
+		errs = append(errs, scpiErr)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Lock session for a multi-command transaction. Calls made with the returned context run
+// inside the transaction, other callers wait until Unlock. Nested Lock with the returned
+// context doesn't block. Waiting for the lock is bounded by ctx. The returned context may
+// be used by several goroutines, their calls don't interleave.
+func (s *Session) Lock(ctx context.Context) (context.Context, error) {
+
+	if lock, ok := ctx.Value(sessionLockKey{}).(*sessionLock); ok && lock.session == s {
+		for depth := lock.depth.Load(); depth > 0; depth = lock.depth.Load() {
+			if lock.depth.CompareAndSwap(depth, depth+1) {
+				return ctx, nil
+			}
+		}
+	}
+	err := s.acquire(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, sessionLockKey{}, newSessionLock(s)), nil
+}
+
+// Finish transaction started by Lock, ctx is the context returned by Lock.
+func (s *Session) Unlock(ctx context.Context) {
+
+	lock, ok := ctx.Value(sessionLockKey{}).(*sessionLock)
+	if !ok || lock.session != s {
+		panic("instruments: unlock of unlocked session")
+	}
+	depth := lock.depth.Add(-1)
+	if depth < 0 {
+		lock.depth.Add(1)
+		panic("instruments: unlock of unlocked session")
+	}
+	if depth == 0 {
+		<-s.sem
+	}
+}
+
+// Run fn as a transaction, fn must talk to the session with the context it is given.
+func (s *Session) WithLock(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	ctx, err := s.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Unlock(ctx)
+	return fn(ctx)
+}
+
+// Get transaction on the session which ctx belongs to, nil if there is none.
+func (s *Session) transaction(ctx context.Context) *sessionLock {
+
+	lock, ok := ctx.Value(sessionLockKey{}).(*sessionLock)
+	if !ok || lock.session != s || lock.depth.Load() <= 0 {
+		return nil
+	}
+	return lock
+}
+
+// Wait for exclusive access to the transport.
+func (s *Session) acquire(ctx context.Context) error {
+
+	s.semOnce.Do(func() {
+		s.sem = make(chan struct{}, 1)
+	})
+	if err := ctx.Err(); err != nil {
+		return contextError(err, "")
+	}
+	select {
+	case s.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx.Err(), "")
+	}
+}
+
+// Run op bounded by ctx with exclusive access to the transport. Transport I/O gets ctx
+// deadline and is interrupted on cancellation, after an interrupted operation device clear
+// discards its leftovers.
+func (s *Session) withContext(ctx context.Context, cmd string, op func() error) error {
+
+	if err := ctx.Err(); err != nil {
+		return contextError(err, cmd)
+	}
+	if lock := s.transaction(ctx); lock != nil {
+		lock.io.Lock()
+		defer lock.io.Unlock()
+	} else {
+		err := s.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { <-s.sem }()
+	}
+	err := s.runBounded(ctx, cmd, op)
+	if s.Reconnect == nil || s.reconnecting || ctx.Err() != nil || !isConnectionLost(err) {
+		return err
+	}
+	s.mu.Lock()
+	identified := s.info.Model != ""
+	s.mu.Unlock()
+	if !identified {
+		return err
+	}
+	reconnectErr := s.reconnect(ctx, err)
+	if reconnectErr != nil {
+		return errors.Wrapf(err, "connection to instr is lost (%v)", reconnectErr)
+	}
+	return errors.Wrap(err, "connection to instr was lost, session is restored")
+}
+
+// Run op with transport I/O bounded by ctx.
+func (s *Session) runBounded(ctx context.Context, cmd string, op func() error) error {
+
+	ds, interruptible := s.Transport.(DeadlineSetter)
+	if ctx.Done() == nil || !interruptible {
+		err := op()
+		if isTimeout(err) {
+			return &TimeoutError{Command: cmd, Err: err}
+		}
+		return err
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		ds.SetDeadline(deadline)
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		ds.SetDeadline(time.Now())
+		close(interrupted)
+	})
+	err := op()
+	if !stop() {
+		// Don't let the interruption outlive the operation
+		<-interrupted
+	}
+	ds.SetDeadline(time.Time{})
+
+	if err != nil && (ctx.Err() != nil || hasDeadline && isTimeout(err) && !time.Now().Before(deadline)) {
+		if clearer, ok := s.Transport.(Clearer); ok {
+			clearer.Clear()
+		}
+		ctxErr := ctx.Err()
+		if ctxErr == nil {
+			ctxErr = context.DeadlineExceeded
+		}
+		return contextError(ctxErr, cmd)
+	}
+	if isTimeout(err) {
+		return &TimeoutError{Command: cmd, Err: err}
+	}
+	return err
+}
+
+// Get instrument identity: manufacturer, model, serial, firmware
+func (s *Session) GetInfo() Identity {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
+// Cast instrument info to string
+func (s *Session) String() string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info.String()
+}
+
+func (s *Session) SetErrorQuery(query string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorQuery = query
+}
+
+// Close instr session, waits for the call or transaction in progress
+func (s *Session) Close() error {
+
+	if s.Transport == nil {
+		return nil
+	}
+	return s.withContext(context.Background(), "", s.ioClose)
+}
+
+// Read from r by chunks until terminator. Bytes after the terminator are returned as pending
+// and must be passed to the next call, the terminator is not included in msg.
+func readTerminated(r io.Reader, pending, terminator []byte, chunkSize int) (msg, rest []byte, err error) {
+
+	chunk := make([]byte, chunkSize)
+	for {
+		if i := bytes.Index(pending, terminator); i >= 0 {
+			msg = append([]byte(nil), pending[0:i]...)
+			return msg, pending[i+len(terminator):], nil
+		}
+		n, err := r.Read(chunk)
+		pending = append(pending, chunk[0:n]...)
+		if err != nil {
+			return nil, pending, err
+		}
+	}
+}
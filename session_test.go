@@ -0,0 +1,258 @@
+package instruments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTransport answers "ECHO? x" with x and "VAL?" with the value set by "VAL x",
+// a Write while a response is still pending means that calls were interleaved.
+type fakeTransport struct {
+	mu          sync.Mutex
+	pending     []string
+	value       string
+	interleaved bool
+}
+
+func (ft *fakeTransport) Open() error { return nil }
+
+func (ft *fakeTransport) Close() error { return nil }
+
+func (ft *fakeTransport) Write(data []byte) error {
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	if len(ft.pending) > 0 {
+		ft.interleaved = true
+	}
+	for _, cmd := range strings.Split(string(data), ";") {
+		switch {
+		case cmd == "*IDN?":
+			ft.pending = append(ft.pending, "Fake,FT1,0001,1.0")
+		case cmd == "SYST:ERR?":
+			ft.pending = append(ft.pending, "+0,\"No error\"")
+		case cmd == "VAL?":
+			ft.pending = append(ft.pending, ft.value)
+		case strings.HasPrefix(cmd, "ECHO? "):
+			ft.pending = append(ft.pending, strings.TrimPrefix(cmd, "ECHO? "))
+		case strings.HasPrefix(cmd, "VAL "):
+			ft.value = strings.TrimPrefix(cmd, "VAL ")
+		}
+	}
+	return nil
+}
+
+func (ft *fakeTransport) Read() ([]byte, error) {
+
+	// Give other goroutines a chance to interleave
+	runtime.Gosched()
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	if len(ft.pending) == 0 {
+		return nil, fmt.Errorf("query unterminated")
+	}
+	response := strings.Join(ft.pending, ";")
+	ft.pending = nil
+	return []byte(response), nil
+}
+
+func TestSessionConcurrentQueries(t *testing.T) {
+
+	ft := &fakeTransport{}
+	session := Session{Transport: ft}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetErrorQuery("SYST:ERR?")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				want := fmt.Sprintf("%d-%d", g, i)
+				response, err := session.Query("ECHO? " + want)
+				if err != nil || response != want {
+					t.Errorf("got %q, %v, expected %q", response, err, want)
+					return
+				}
+				err = session.Write("VAL " + want)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if ft.interleaved {
+		t.Error("session calls were interleaved")
+	}
+}
+
+func TestSessionWithLock(t *testing.T) {
+
+	session := Session{Transport: &fakeTransport{}}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetErrorQuery("SYST:ERR?")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				want := fmt.Sprintf("%d-%d", g, i)
+				err := session.WithLock(context.Background(), func(ctx context.Context) error {
+					err := session.WriteContext(ctx, "VAL "+want)
+					if err != nil {
+						return err
+					}
+					// Nested transaction of a driver doesn't block
+					ctx, unlock, err := lockInstr(ctx, &session)
+					if err != nil {
+						return err
+					}
+					defer unlock()
+					response, err := session.QueryContext(ctx, "VAL?")
+					if err != nil {
+						return err
+					}
+					if response != want {
+						return fmt.Errorf("transaction was broken: got %q, expected %q", response, want)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Waiting for the lock is bounded by context
+	ctx, err := session.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = session.QueryContext(timeoutCtx, "ECHO? 1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout while session is locked, got %v", err)
+	}
+	session.Unlock(ctx)
+	_, err = session.QueryContext(ctx, "ECHO? 1")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSessionSharedTransaction(t *testing.T) {
+
+	ft := &fakeTransport{}
+	session := Session{Transport: ft}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetErrorQuery("SYST:ERR?")
+
+	// Goroutines share the transaction context, their calls must not interleave
+	err = session.WithLock(context.Background(), func(ctx context.Context) error {
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					ctx, unlock, err := lockInstr(ctx, &session)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					want := fmt.Sprintf("%d-%d", g, i)
+					response, err := session.QueryContext(ctx, "ECHO? "+want)
+					unlock()
+					if err != nil || response != want {
+						t.Errorf("got %q, %v, expected %q", response, err, want)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ft.interleaved {
+		t.Error("calls of the transaction were interleaved")
+	}
+	_, err = session.QueryContext(context.Background(), "ECHO? 1")
+	if err != nil {
+		t.Errorf("session must be unlocked after the transaction, got %v", err)
+	}
+}
+
+func TestKeithley2400Concurrent(t *testing.T) {
+
+	sim := NewKeithley2400Simulator(Resistor{Resistance: 1e3})
+	session := Session{Transport: sim}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ke2400 := Keithley2400{}
+	err = ke2400.Init(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Write("OUTP ON")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				err := ke2400.SetFixedRangeVoltageSource(float64(g+1), 0.1, 1, false)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				_, _, err := ke2400.ReadSrcData()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
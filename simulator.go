@@ -0,0 +1,361 @@
+package instruments
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SCPI error numbers used by the simulators
+const (
+	scpiUndefinedHeader    = -113
+	scpiMissingParameter   = -109
+	scpiDataTypeError      = -104
+	scpiIllegalParameter   = -224
+	scpiDataOutOfRange     = -222
+	scpiTooMuchData        = -223
+	scpiSettingsConflict   = -221
+	scpiQueryUnterminated  = -420
+	scpiErrorQueueOverflow = -350
+	scpiErrorQueueSize     = 10
+)
+
+var scpiErrorMessages = map[int]string{
+	scpiUndefinedHeader:    "Undefined header",
+	scpiMissingParameter:   "Missing parameter",
+	scpiDataTypeError:      "Data type error",
+	scpiIllegalParameter:   "Illegal parameter value",
+	scpiDataOutOfRange:     "Data out of range",
+	scpiTooMuchData:        "Too much data",
+	scpiSettingsConflict:   "Settings conflict",
+	scpiQueryUnterminated:  "Query UNTERMINATED",
+	scpiErrorQueueOverflow: "Queue overflow",
+}
+
+// simError is an entry of the simulated error queue, message is taken from
+// scpiErrorMessages if not set.
+type simError struct {
+	code    int
+	message string
+}
+
+func (e simError) Error() string {
+	message := e.message
+	if message == "" {
+		message = scpiErrorMessages[e.code]
+	}
+	return fmt.Sprintf("%+d,\"%s\"", e.code, message)
+}
+
+type simHandler struct {
+	nodes []simNode
+	query bool
+	run   func(args string) (string, error)
+}
+
+type simNode struct {
+	short    string
+	long     string
+	optional bool
+}
+
+// Node of SCPI manual notation, e.g. "VOLTage" has short form "VOLT" and long form "VOLTAGE".
+func newSimNode(mnemonic string) simNode {
+
+	short := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return -1
+		}
+		return r
+	}, mnemonic)
+	return simNode{short: short, long: strings.ToUpper(mnemonic)}
+}
+
+func (node simNode) match(mnemonic string) bool {
+	mnemonic = strings.ToUpper(mnemonic)
+	return mnemonic == node.short || mnemonic == node.long
+}
+
+var simNodePattern = regexp.MustCompile(`(\[?):?([A-Za-z0-9*]+)\]?`)
+
+// scpiSimulator is an in-process SCPI instrument implementing Transport. It splits compound
+// messages, resolves relative headers, matches short/long mnemonics and keeps an error queue.
+type scpiSimulator struct {
+	mu       sync.Mutex
+	handlers []simHandler
+	output   []string
+	errQueue []simError
+	open     bool
+
+	// IEEE-488.2 status model, operations complete immediately
+	esr, ese, sre       byte
+	operEvent, operEnab uint16
+	quesEvent, quesEnab uint16
+}
+
+// Register handler for header pattern written as in SCPI manuals, e.g. "[SOURce]:VOLTage[:LEVel]?".
+func (sim *scpiSimulator) handle(pattern string, run func(args string) (string, error)) {
+
+	handler := simHandler{run: run}
+	if strings.HasSuffix(pattern, "?") {
+		handler.query = true
+		pattern = strings.TrimSuffix(pattern, "?")
+	}
+	for _, match := range simNodePattern.FindAllStringSubmatch(pattern, -1) {
+		node := newSimNode(match[2])
+		node.optional = match[1] == "["
+		handler.nodes = append(handler.nodes, node)
+	}
+	sim.handlers = append(sim.handlers, handler)
+}
+
+// Register common IEEE-488.2 commands shared by all simulators.
+func (sim *scpiSimulator) handleCommon(idn func() string, reset func()) {
+
+	sim.handle("*IDN?", func(string) (string, error) { return idn(), nil })
+	sim.handle("*RST", func(string) (string, error) { reset(); return "", nil })
+	sim.handle("*CLS", func(string) (string, error) {
+		sim.errQueue = nil
+		sim.esr, sim.operEvent, sim.quesEvent = 0, 0, 0
+		return "", nil
+	})
+	sim.handle("*OPC", func(string) (string, error) { sim.esr |= byte(EventOperationComplete); return "", nil })
+	sim.handle("*OPC?", func(string) (string, error) { return "1", nil })
+	sim.handle("SYSTem:ERRor[:NEXT]?", func(string) (string, error) { return sim.popError(), nil })
+	sim.handle("*STB?", func(string) (string, error) { return fmt.Sprintf("%d", sim.statusByte()), nil })
+	sim.handle("*ESR?", func(string) (string, error) {
+		esr := sim.esr
+		sim.esr = 0
+		return fmt.Sprintf("%d", esr), nil
+	})
+	sim.handleRegister("*SRE", &sim.sre)
+	sim.handleRegister("*ESE", &sim.ese)
+	sim.handleRegister16("STATus:OPERation:ENABle", &sim.operEnab)
+	sim.handleRegister16("STATus:QUEStionable:ENABle", &sim.quesEnab)
+	sim.handle("STATus:OPERation[:EVENt]?", func(string) (string, error) {
+		event := sim.operEvent
+		sim.operEvent = 0
+		return fmt.Sprintf("%d", event), nil
+	})
+	sim.handle("STATus:QUEStionable[:EVENt]?", func(string) (string, error) {
+		event := sim.quesEvent
+		sim.quesEvent = 0
+		return fmt.Sprintf("%d", event), nil
+	})
+	sim.handle("STATus:OPERation:CONDition?", func(string) (string, error) { return "0", nil })
+	sim.handle("STATus:QUEStionable:CONDition?", func(string) (string, error) { return "0", nil })
+}
+
+// Register set and query of 8-bit enable register.
+func (sim *scpiSimulator) handleRegister(header string, register *byte) {
+
+	sim.handle(header, func(args string) (string, error) {
+		value, err := strconv.ParseUint(args, 10, 8)
+		if err != nil {
+			return "", simError{code: scpiDataOutOfRange}
+		}
+		*register = byte(value)
+		return "", nil
+	})
+	sim.handle(header+"?", func(string) (string, error) { return fmt.Sprintf("%d", *register), nil })
+}
+
+// Register set and query of 16-bit enable register.
+func (sim *scpiSimulator) handleRegister16(header string, register *uint16) {
+
+	sim.handle(header, func(args string) (string, error) {
+		value, err := strconv.ParseUint(args, 10, 16)
+		if err != nil {
+			return "", simError{code: scpiDataOutOfRange}
+		}
+		*register = uint16(value)
+		return "", nil
+	})
+	sim.handle(header+"?", func(string) (string, error) { return fmt.Sprintf("%d", *register), nil })
+}
+
+// Status byte summarizing error queue, output queue and event registers.
+func (sim *scpiSimulator) statusByte() byte {
+
+	var stb StatusByte
+	if len(sim.errQueue) > 0 {
+		stb |= StatusErrorQueue
+	}
+	if sim.quesEvent&sim.quesEnab != 0 {
+		stb |= StatusQuestionable
+	}
+	if len(sim.output) > 0 {
+		stb |= StatusMessageAvailable
+	}
+	if sim.esr&sim.ese != 0 {
+		stb |= StatusEventStatus
+	}
+	if sim.operEvent&sim.operEnab != 0 {
+		stb |= StatusOperation
+	}
+	if byte(stb)&sim.sre != 0 {
+		stb |= StatusRequestService
+	}
+	return byte(stb)
+}
+
+func (sim *scpiSimulator) pushError(err simError) {
+
+	class := (&SCPIError{Code: err.code}).Class()
+	switch class {
+	case ErrSCPICommand:
+		sim.esr |= byte(EventCommandError)
+	case ErrSCPIExecution:
+		sim.esr |= byte(EventExecutionError)
+	case ErrSCPIDevice:
+		sim.esr |= byte(EventDeviceError)
+	case ErrSCPIQuery:
+		sim.esr |= byte(EventQueryError)
+	}
+
+	if len(sim.errQueue) >= scpiErrorQueueSize {
+		sim.errQueue[len(sim.errQueue)-1] = simError{code: scpiErrorQueueOverflow}
+		return
+	}
+	sim.errQueue = append(sim.errQueue, err)
+}
+
+func (sim *scpiSimulator) popError() string {
+
+	if len(sim.errQueue) == 0 {
+		return "+0,\"No error\""
+	}
+	err := sim.errQueue[0]
+	sim.errQueue = sim.errQueue[1:]
+	return err.Error()
+}
+
+func (sim *scpiSimulator) Open() error {
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.open = true
+	sim.output = nil
+	return nil
+}
+
+// Execute program message, responses of its queries are joined with ";".
+func (sim *scpiSimulator) Write(data []byte) error {
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	if !sim.open {
+		return fmt.Errorf("simulator is not open")
+	}
+	// A new message discards unread response
+	sim.output = nil
+	var responses []string
+	var path []string
+	for _, unit := range splitProgramMessage(strings.TrimRight(string(data), "\r\n")) {
+		header, args, _ := strings.Cut(strings.TrimSpace(unit), " ")
+		args = strings.TrimSpace(args)
+		if header == "" {
+			continue
+		}
+		var nodes []string
+		if strings.HasPrefix(header, "*") {
+			nodes = []string{header}
+		} else {
+			nodes = strings.Split(strings.TrimPrefix(header, ":"), ":")
+			if !strings.HasPrefix(header, ":") && len(path) > 0 {
+				nodes = append(append([]string(nil), path...), nodes...)
+			}
+			path = nodes[0 : len(nodes)-1]
+		}
+		response, err := sim.execute(nodes, args)
+		if err != nil {
+			if simErr, ok := err.(simError); ok {
+				sim.pushError(simErr)
+				continue
+			}
+			return err
+		}
+		if strings.HasSuffix(header, "?") {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) > 0 {
+		sim.output = append(sim.output, strings.Join(responses, ";"))
+	}
+	return nil
+}
+
+func (sim *scpiSimulator) Read() ([]byte, error) {
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	if !sim.open {
+		return nil, fmt.Errorf("simulator is not open")
+	}
+	if len(sim.output) == 0 {
+		sim.pushError(simError{code: scpiQueryUnterminated})
+		return nil, fmt.Errorf("simulated read timeout: no response pending")
+	}
+	response := sim.output[0]
+	sim.output = sim.output[1:]
+	return []byte(response + "\n"), nil
+}
+
+func (sim *scpiSimulator) Close() error {
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.open = false
+	return nil
+}
+
+func (sim *scpiSimulator) execute(nodes []string, args string) (string, error) {
+
+	query := strings.HasSuffix(nodes[len(nodes)-1], "?")
+	nodes[len(nodes)-1] = strings.TrimSuffix(nodes[len(nodes)-1], "?")
+	for _, handler := range sim.handlers {
+		if handler.query == query && matchNodes(handler.nodes, nodes) {
+			return handler.run(args)
+		}
+	}
+	return "", simError{code: scpiUndefinedHeader}
+}
+
+func matchNodes(pattern []simNode, nodes []string) bool {
+
+	if len(pattern) == 0 {
+		return len(nodes) == 0
+	}
+	if len(nodes) > 0 {
+		if pattern[0].match(nodes[0]) && matchNodes(pattern[1:], nodes[1:]) {
+			return true
+		}
+	}
+	return pattern[0].optional && matchNodes(pattern[1:], nodes)
+}
+
+// Split program message into units by ";" outside of quoted strings.
+func splitProgramMessage(msg string) []string {
+
+	var units []string
+	var quote rune
+	start := 0
+	for i, r := range msg {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ';':
+			units = append(units, msg[start:i])
+			start = i + 1
+		}
+	}
+	return append(units, msg[start:])
+}
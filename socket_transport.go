@@ -0,0 +1,118 @@
+package instruments
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSocketPort    = 5025
+	defaultSocketTimeout = 10 * time.Second
+)
+
+// SocketTransport implements Transport over a raw SCPI socket (TCPIP::host::5025::SOCKET).
+type SocketTransport struct {
+	Address          string        // host or host:port, port 5025 by default
+	WriteTermination string        // appended to every written message, "\n" by default
+	ReadTermination  byte          // end of response message, '\n' by default
+	Timeout          time.Duration // connect and read timeout, 10 s by default
+	ChunkSize        int           // size of single socket read, bufferSize by default
+	conn             net.Conn
+	pending          []byte
+	deadline         ioDeadline
+}
+
+func (st *SocketTransport) Open() error {
+
+	if st.WriteTermination == "" {
+		st.WriteTermination = "\n"
+	}
+	if st.ReadTermination == 0 {
+		st.ReadTermination = '\n'
+	}
+	if st.Timeout == 0 {
+		st.Timeout = defaultSocketTimeout
+	}
+	if st.ChunkSize == 0 {
+		st.ChunkSize = bufferSize
+	}
+
+	address := st.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(defaultSocketPort))
+	}
+	conn, err := net.DialTimeout("tcp", address, st.Timeout)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't connect to \"%s\"", address)
+	}
+	st.conn = conn
+	st.pending = nil
+	return nil
+}
+
+func (st *SocketTransport) Write(data []byte) error {
+
+	if st.conn == nil {
+		return fmt.Errorf("socket to \"%s\" is not open", st.Address)
+	}
+	msg := make([]byte, 0, len(data)+len(st.WriteTermination))
+	msg = append(msg, data...)
+	msg = append(msg, st.WriteTermination...)
+	err := st.deadline.apply(st.Timeout, st.conn.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	_, err = st.conn.Write(msg)
+	return err
+}
+
+// Read response until ReadTermination, the terminator is not included in result.
+func (st *SocketTransport) Read() ([]byte, error) {
+
+	if st.conn == nil {
+		return nil, fmt.Errorf("socket to \"%s\" is not open", st.Address)
+	}
+	err := st.deadline.apply(st.Timeout, st.conn.SetReadDeadline)
+	if err != nil {
+		return nil, err
+	}
+	msg, pending, err := readTerminated(st.conn, st.pending, []byte{st.ReadTermination}, st.ChunkSize)
+	st.pending = pending
+	return msg, err
+}
+
+func (st *SocketTransport) readTermination() []byte {
+	return []byte{st.ReadTermination}
+}
+
+func (st *SocketTransport) Close() error {
+
+	if st.conn == nil {
+		return nil
+	}
+	err := st.conn.Close()
+	st.conn = nil
+	return err
+}
+
+// Bound I/O by t, I/O in progress is interrupted if t is in the past.
+func (st *SocketTransport) SetDeadline(t time.Time) error {
+
+	if st.conn == nil {
+		return st.deadline.setLimit(t, nil)
+	}
+	return st.deadline.setLimit(t, st.conn.SetDeadline)
+}
+
+// Raw socket has no device clear, reconnect to drop unread and partial responses.
+func (st *SocketTransport) Clear() error {
+
+	err := st.Close()
+	if err != nil {
+		return err
+	}
+	return st.Open()
+}
@@ -0,0 +1,82 @@
+package instruments
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSocketServer runs a raw SCPI socket stand-in answering with responses[cmd].
+func startSocketServer(t *testing.T, responses map[string]string) string {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if response, ok := responses[strings.TrimSpace(line)]; ok {
+						conn.Write([]byte(response + "\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSocketTransport(t *testing.T) {
+
+	longResponse := strings.Repeat("1,", 1000) + "1"
+	addr := startSocketServer(t, map[string]string{
+		"*IDN?":     "Agilent Technologies,34980A,MY44001234,2.41-2.41-2.41-2.41",
+		"SYST:ERR?": "+0,\"No error\"",
+		"LONG?":     longResponse,
+	})
+
+	session := Session{Transport: &SocketTransport{Address: addr, ChunkSize: 16, Timeout: 200 * time.Millisecond}}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.SetErrorQuery("SYST:ERR?")
+
+	if session.GetInfo().Model != "34980A" {
+		t.Errorf("unexpected model %q", session.GetInfo().Model)
+	}
+
+	response, err := session.Query("LONG?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != longResponse {
+		t.Errorf("chunked response is corrupted, got %d bytes", len(response))
+	}
+
+	err = session.Write("*RST")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = session.ReadBytes()
+	if err == nil {
+		t.Error("read without response must fail on timeout")
+	}
+}
@@ -0,0 +1,323 @@
+package instruments
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Interval of status polling when transport can't wait for events itself.
+const statusPollInterval = 10 * time.Millisecond
+
+// StatusByte is IEEE-488.2 status byte (*STB?, serial poll).
+type StatusByte byte
+
+const (
+	StatusErrorQueue       StatusByte = 1 << 2 // EAV, error queue is not empty
+	StatusQuestionable     StatusByte = 1 << 3 // QSB, questionable status summary
+	StatusMessageAvailable StatusByte = 1 << 4 // MAV, response is ready
+	StatusEventStatus      StatusByte = 1 << 5 // ESB, enabled standard event occurred
+	StatusRequestService   StatusByte = 1 << 6 // RQS/MSS, service request
+	StatusOperation        StatusByte = 1 << 7 // OSB, operation status summary
+)
+
+var statusByteNames = []string{"bit0", "bit1", "EAV", "QSB", "MAV", "ESB", "RQS", "OSB"}
+
+func (stb StatusByte) String() string {
+	return bitNames(uint32(stb), statusByteNames)
+}
+
+// EventStatus is IEEE-488.2 standard event status register (*ESR?).
+type EventStatus byte
+
+const (
+	EventOperationComplete EventStatus = 1 << 0
+	EventRequestControl    EventStatus = 1 << 1
+	EventQueryError        EventStatus = 1 << 2
+	EventDeviceError       EventStatus = 1 << 3
+	EventExecutionError    EventStatus = 1 << 4
+	EventCommandError      EventStatus = 1 << 5
+	EventUserRequest       EventStatus = 1 << 6
+	EventPowerOn           EventStatus = 1 << 7
+
+	// Any of error events
+	EventErrors = EventQueryError | EventDeviceError | EventExecutionError | EventCommandError
+)
+
+var eventStatusNames = []string{"OPC", "RQC", "QYE", "DDE", "EXE", "CME", "URQ", "PON"}
+
+func (esr EventStatus) String() string {
+	return bitNames(uint32(esr), eventStatusNames)
+}
+
+func bitNames(value uint32, names []string) string {
+
+	var set []string
+	for i, name := range names {
+		if value&(1<<i) != 0 {
+			set = append(set, name)
+		}
+	}
+	if len(set) == 0 {
+		return "0"
+	}
+	return strings.Join(set, "|")
+}
+
+// StatusByteReader is implemented by transports able to read status byte out of band
+// (serial poll, VXI-11 device_readstb, HiSLIP AsyncStatusQuery).
+type StatusByteReader interface {
+	ReadSTB() (byte, error)
+}
+
+// SRQWaiter is implemented by transports delivering service requests.
+type SRQWaiter interface {
+	WaitForSRQContext(ctx context.Context) (byte, error)
+}
+
+// StatusInstrument is an Instrument with IEEE-488.2 status model.
+type StatusInstrument interface {
+	Instrument
+	ReadStatusByte() (StatusByte, error)
+	ReadEventStatus() (EventStatus, error)
+	SetServiceRequestEnable(mask StatusByte) error
+	SetEventStatusEnable(mask EventStatus) error
+	WaitForOPCContext(ctx context.Context) error
+	WaitForSRQContext(ctx context.Context) (StatusByte, error)
+}
+
+var _ StatusInstrument = (*Session)(nil)
+
+// Read status byte, out of band if transport can, otherwise with *STB?.
+func (s *Session) ReadStatusByte() (StatusByte, error) {
+	return s.ReadStatusByteContext(context.Background())
+}
+
+func (s *Session) ReadStatusByteContext(ctx context.Context) (StatusByte, error) {
+
+	if reader, ok := s.Transport.(StatusByteReader); ok {
+		var stb byte
+		err := s.withContext(ctx, "*STB?", func() error {
+			var err error
+			stb, err = reader.ReadSTB()
+			return err
+		})
+		return StatusByte(stb), err
+	}
+	value, err := s.queryRegister(ctx, "*STB?")
+	return StatusByte(value), err
+}
+
+// Read and clear standard event status register.
+func (s *Session) ReadEventStatus() (EventStatus, error) {
+	return s.ReadEventStatusContext(context.Background())
+}
+
+func (s *Session) ReadEventStatusContext(ctx context.Context) (EventStatus, error) {
+
+	value, err := s.queryRegister(ctx, "*ESR?")
+	return EventStatus(value), err
+}
+
+// Set status byte bits generating service request (*SRE).
+func (s *Session) SetServiceRequestEnable(mask StatusByte) error {
+	return s.Write(fmt.Sprintf("*SRE %d", mask&^StatusRequestService))
+}
+
+// Set standard events summarized in ESB bit of status byte (*ESE).
+func (s *Session) SetEventStatusEnable(mask EventStatus) error {
+	return s.Write(fmt.Sprintf("*ESE %d", mask))
+}
+
+// Set operation events summarized in OSB bit of status byte (STAT:OPER:ENAB).
+func (s *Session) SetOperationEnable(mask uint16) error {
+	return s.Write(fmt.Sprintf("STAT:OPER:ENAB %d", mask))
+}
+
+// Set questionable events summarized in QSB bit of status byte (STAT:QUES:ENAB).
+func (s *Session) SetQuestionableEnable(mask uint16) error {
+	return s.Write(fmt.Sprintf("STAT:QUES:ENAB %d", mask))
+}
+
+// Read and clear operation event register.
+func (s *Session) ReadOperationEvent() (uint16, error) {
+	return s.ReadOperationEventContext(context.Background())
+}
+
+func (s *Session) ReadOperationEventContext(ctx context.Context) (uint16, error) {
+
+	value, err := s.queryRegister(ctx, "STAT:OPER?")
+	return uint16(value), err
+}
+
+// Read operation condition register.
+func (s *Session) ReadOperationCondition() (uint16, error) {
+	return s.ReadOperationConditionContext(context.Background())
+}
+
+func (s *Session) ReadOperationConditionContext(ctx context.Context) (uint16, error) {
+
+	value, err := s.queryRegister(ctx, "STAT:OPER:COND?")
+	return uint16(value), err
+}
+
+// Read and clear questionable event register.
+func (s *Session) ReadQuestionableEvent() (uint16, error) {
+	return s.ReadQuestionableEventContext(context.Background())
+}
+
+func (s *Session) ReadQuestionableEventContext(ctx context.Context) (uint16, error) {
+
+	value, err := s.queryRegister(ctx, "STAT:QUES?")
+	return uint16(value), err
+}
+
+// Read questionable condition register.
+func (s *Session) ReadQuestionableCondition() (uint16, error) {
+	return s.ReadQuestionableConditionContext(context.Background())
+}
+
+func (s *Session) ReadQuestionableConditionContext(ctx context.Context) (uint16, error) {
+
+	value, err := s.queryRegister(ctx, "STAT:QUES:COND?")
+	return uint16(value), err
+}
+
+// Wait for pending operations with *OPC?, the response must arrive within transport timeout.
+func (s *Session) OperationComplete(ctx context.Context) error {
+
+	response, err := s.QueryContext(ctx, "*OPC?")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(response) != "1" {
+		return fmt.Errorf("unexpected *OPC? response \"%s\"", response)
+	}
+	return nil
+}
+
+// Wait up to timeout for pending operations. Unlike *OPC? no I/O waits for the instr: *OPC
+// sets OPC event which is polled with *ESR?. Reading *ESR? clears events, so the session is
+// held for the whole wait and other callers block until it ends. Error events are reported
+// with the queue.
+func (s *Session) WaitForOPC(timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.WaitForOPCContext(ctx)
+}
+
+func (s *Session) WaitForOPCContext(ctx context.Context) error {
+
+	return s.WithLock(ctx, func(ctx context.Context) error {
+		// Events left from previous operations are discarded
+		_, err := s.ReadEventStatusContext(ctx)
+		if err != nil {
+			return errors.Wrap(err, "waiting for operation complete failed")
+		}
+		err = s.WriteWithoutCheckContext(ctx, "*OPC")
+		if err != nil {
+			return err
+		}
+		for {
+			esr, err := s.ReadEventStatusContext(ctx)
+			if err != nil {
+				return errors.Wrap(err, "waiting for operation complete failed")
+			}
+			if esr&EventErrors != 0 {
+				instrErr := s.CheckErrorsContext(ctx)
+				if instrErr != nil {
+					return errors.Wrap(instrErr, "an instr error occurred while waiting for operation complete")
+				}
+				return fmt.Errorf("instr reported error events %s", esr)
+			}
+			if esr&EventOperationComplete != 0 {
+				return nil
+			}
+			err = sleepContext(ctx, statusPollInterval)
+			if err != nil {
+				return contextError(err, "*OPC")
+			}
+		}
+	})
+}
+
+// Wait up to timeout for service request and return status byte. Enable its sources with
+// SetServiceRequestEnable first. Transport delivering SRQ is used if possible, otherwise
+// status byte is polled.
+func (s *Session) WaitForSRQ(timeout time.Duration) (StatusByte, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.WaitForSRQContext(ctx)
+}
+
+func (s *Session) WaitForSRQContext(ctx context.Context) (StatusByte, error) {
+
+	if waiter, ok := s.Transport.(SRQWaiter); ok {
+		stb, err := waiter.WaitForSRQContext(ctx)
+		if err != nil && ctx.Err() != nil {
+			return 0, contextError(ctx.Err(), "")
+		}
+		return StatusByte(stb), err
+	}
+	for {
+		stb, err := s.ReadStatusByteContext(ctx)
+		if err != nil {
+			return 0, errors.Wrap(err, "waiting for service request failed")
+		}
+		if stb&StatusRequestService != 0 {
+			return stb, nil
+		}
+		err = sleepContext(ctx, statusPollInterval)
+		if err != nil {
+			return 0, contextError(err, "")
+		}
+	}
+}
+
+// Query register value, e.g. "+32" for *ESR?.
+func (s *Session) queryRegister(ctx context.Context, cmd string) (int, error) {
+
+	response, err := s.QueryContext(ctx, cmd)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(response), "+"))
+	if err != nil {
+		return 0, errors.Wrapf(err, "unexpected \"%s\" response", cmd)
+	}
+	return value, nil
+}
+
+// Wait for pending operations of instr, drivers use it with any Instrument.
+func waitForOPC(ctx context.Context, instr Instrument) error {
+
+	if si, ok := instr.(StatusInstrument); ok {
+		return si.WaitForOPCContext(ctx)
+	}
+	response, err := bindContext(ctx, instr).Query("*OPC?")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(response) != "1" {
+		return fmt.Errorf("unexpected *OPC? response \"%s\"", response)
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
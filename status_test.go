@@ -0,0 +1,116 @@
+package instruments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionStatus(t *testing.T) {
+
+	session := Session{Transport: NewAgilent34980ASimulator(1)}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetErrorQuery("SYST:ERR?")
+
+	err = session.SetEventStatusEnable(EventErrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.SetServiceRequestEnable(StatusEventStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No service request yet
+	_, err = session.WaitForSRQ(30 * time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	err = session.WriteWithoutCheck("FOO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stb, err := session.WaitForSRQ(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := StatusErrorQueue | StatusEventStatus | StatusRequestService
+	if stb != expected {
+		t.Errorf("expected status byte %s, got %s", expected, stb)
+	}
+	esr, err := session.ReadEventStatus()
+	if err != nil || esr != EventCommandError {
+		t.Errorf("expected %s, got %s, %v", EventCommandError, esr, err)
+	}
+	esr, err = session.ReadEventStatus()
+	if err != nil || esr != 0 {
+		t.Errorf("*ESR? must clear the register, got %s, %v", esr, err)
+	}
+	err = session.CheckErrors()
+	if !errors.Is(err, ErrSCPICommand) {
+		t.Errorf("expected command error, got %v", err)
+	}
+	stb, err = session.ReadStatusByte()
+	if err != nil || stb != 0 {
+		t.Errorf("expected clear status byte, got %s, %v", stb, err)
+	}
+
+	err = session.WaitForOPC(time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	err = session.OperationComplete(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	err = session.SetOperationEnable(1 << 4)
+	if err != nil {
+		t.Error(err)
+	}
+	event, err := session.ReadOperationEvent()
+	if err != nil || event != 0 {
+		t.Errorf("unexpected operation event %d, %v", event, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = session.ReadQuestionableConditionContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+}
+
+func TestDriverWaitForOPC(t *testing.T) {
+
+	session := Session{Transport: NewKeithley2400Simulator(Resistor{Resistance: 1e3})}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ke2400 := Keithley2400{}
+	err = ke2400.Init(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ke2400.WaitForOPC(time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStatusString(t *testing.T) {
+
+	if s := (StatusMessageAvailable | StatusRequestService).String(); s != "MAV|RQS" {
+		t.Errorf("unexpected %q", s)
+	}
+	if s := (EventOperationComplete | EventPowerOn).String(); s != "OPC|PON" {
+		t.Errorf("unexpected %q", s)
+	}
+	if s := EventStatus(0).String(); s != "0" {
+		t.Errorf("unexpected %q", s)
+	}
+}
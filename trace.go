@@ -0,0 +1,279 @@
+package instruments
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IOOp is a transport operation reported to IOObserver.
+type IOOp string
+
+const (
+	IOOpen  IOOp = "OPEN"
+	IOWrite IOOp = "WRITE"
+	IORead  IOOp = "READ"
+	IOClose IOOp = "CLOSE"
+)
+
+// IOEvent is a single transport operation of a Session.
+type IOEvent struct {
+	Time       time.Time // start of operation
+	Op         IOOp
+	Data       []byte // written or read message
+	Duration   time.Duration
+	VISAStatus VISAStatus // status of VISA operation, VISA transport only
+	Err        error
+}
+
+// VISAStatus is a ViStatus code returned by VISA library, negative codes are errors.
+type VISAStatus int32
+
+// IOObserver is notified about every transport operation of a Session.
+type IOObserver interface {
+	ObserveIO(event IOEvent)
+}
+
+// IOObserverFunc adapts a function to IOObserver.
+type IOObserverFunc func(event IOEvent)
+
+func (f IOObserverFunc) ObserveIO(event IOEvent) {
+	f(event)
+}
+
+// Notify all observers.
+func MultiObserver(observers ...IOObserver) IOObserver {
+
+	return IOObserverFunc(func(event IOEvent) {
+		for _, observer := range observers {
+			observer.ObserveIO(event)
+		}
+	})
+}
+
+// visaStatusReporter is implemented by VISA transport to report status of the last operation.
+type visaStatusReporter interface {
+	lastVISAStatus() VISAStatus
+}
+
+func (s *Session) ioOpen() error {
+	return s.observe(IOOpen, nil, func() ([]byte, error) { return nil, s.Transport.Open() })
+}
+
+func (s *Session) ioWrite(data []byte) error {
+	return s.observe(IOWrite, data, func() ([]byte, error) { return nil, s.Transport.Write(data) })
+}
+
+func (s *Session) ioRead() ([]byte, error) {
+
+	var data []byte
+	err := s.observe(IORead, nil, func() ([]byte, error) {
+		var err error
+		data, err = s.Transport.Read()
+		return data, err
+	})
+	return data, err
+}
+
+func (s *Session) ioClose() error {
+	return s.observe(IOClose, nil, func() ([]byte, error) { return nil, s.Transport.Close() })
+}
+
+// Run transport operation and report it to the session observer.
+func (s *Session) observe(op IOOp, data []byte, run func() ([]byte, error)) error {
+
+	if s.Observer == nil {
+		_, err := run()
+		return err
+	}
+	start := time.Now()
+	read, err := run()
+	event := IOEvent{Time: start, Op: op, Data: data, Duration: time.Since(start), Err: err}
+	if op == IORead {
+		event.Data = read
+	}
+	if reporter, ok := s.Transport.(visaStatusReporter); ok {
+		event.VISAStatus = reporter.lastVISAStatus()
+	}
+	s.Observer.ObserveIO(event)
+	return err
+}
+
+// SlogObserver logs transport operations with slog, failed ones with warning level.
+type SlogObserver struct {
+	Logger *slog.Logger // slog.Default() if nil
+	Level  slog.Level   // level of successful operations, debug by default
+}
+
+func (so *SlogObserver) ObserveIO(event IOEvent) {
+
+	logger := so.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := []slog.Attr{
+		slog.String("op", string(event.Op)),
+		slog.Duration("duration", event.Duration),
+	}
+	if event.Data != nil {
+		attrs = append(attrs, slog.String("data", string(event.Data)))
+	}
+	if event.VISAStatus != 0 {
+		attrs = append(attrs, slog.Int("visa_status", int(event.VISAStatus)))
+	}
+	level := so.Level
+	if event.Err != nil {
+		attrs = append(attrs, slog.String("error", event.Err.Error()))
+		level = slog.LevelWarn
+	}
+	logger.LogAttrs(context.Background(), level, "instr io", attrs...)
+}
+
+// FileTracer writes transport operations as a transcript, one operation per line:
+//
+//	<RFC 3339 time> <op> <duration> <quoted data> [status=<VISA status>] [error=<quoted error>]
+//
+// Transcript is read back with ReadTranscript.
+type FileTracer struct {
+	mu   sync.Mutex
+	w    *bufio.Writer
+	file *os.File
+}
+
+// Create transcript file.
+func NewFileTracer(path string) (*FileTracer, error) {
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create transcript")
+	}
+	return &FileTracer{w: bufio.NewWriter(file), file: file}, nil
+}
+
+// Write transcript to w.
+func NewTracer(w io.Writer) *FileTracer {
+	return &FileTracer{w: bufio.NewWriter(w)}
+}
+
+func (ft *FileTracer) ObserveIO(event IOEvent) {
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.w.WriteString(formatTranscriptLine(event))
+	ft.w.Flush()
+}
+
+// Close transcript file.
+func (ft *FileTracer) Close() error {
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	err := ft.w.Flush()
+	if ft.file != nil {
+		closeErr := ft.file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func formatTranscriptLine(event IOEvent) string {
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s %s %s %q", event.Time.Format(time.RFC3339Nano), event.Op, event.Duration, event.Data)
+	if event.VISAStatus != 0 {
+		fmt.Fprintf(&line, " status=%d", event.VISAStatus)
+	}
+	if event.Err != nil {
+		fmt.Fprintf(&line, " error=%q", event.Err.Error())
+	}
+	line.WriteString("\n")
+	return line.String()
+}
+
+// Read transcript written by FileTracer, errors are restored as plain text errors.
+func ReadTranscript(r io.Reader) ([]IOEvent, error) {
+
+	var events []IOEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		event, err := parseTranscriptLine(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "transcript line %d", lineNum)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+func parseTranscriptLine(line string) (IOEvent, error) {
+
+	var event IOEvent
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 4 {
+		return event, fmt.Errorf("malformed line %q", line)
+	}
+	var err error
+	event.Time, err = time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return event, err
+	}
+	event.Op = IOOp(fields[1])
+	event.Duration, err = time.ParseDuration(fields[2])
+	if err != nil {
+		return event, err
+	}
+	rest := fields[3]
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return event, fmt.Errorf("malformed data in %q", line)
+	}
+	data, _ := strconv.Unquote(quoted)
+	if data != "" {
+		event.Data = []byte(data)
+	}
+	rest = strings.TrimSpace(rest[len(quoted):])
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return event, fmt.Errorf("malformed attribute in %q", line)
+		}
+		switch key {
+		case "status":
+			statusStr, tail, _ := strings.Cut(value, " ")
+			status, err := strconv.Atoi(statusStr)
+			if err != nil {
+				return event, fmt.Errorf("malformed status in %q", line)
+			}
+			event.VISAStatus = VISAStatus(status)
+			rest = tail
+		case "error":
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return event, fmt.Errorf("malformed error in %q", line)
+			}
+			message, _ := strconv.Unquote(quoted)
+			event.Err = errors.New(message)
+			rest = value[len(quoted):]
+		default:
+			return event, fmt.Errorf("unknown attribute %q", key)
+		}
+		rest = strings.TrimSpace(rest)
+	}
+	return event, nil
+}
@@ -0,0 +1,101 @@
+package instruments
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionObserver(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "session.trace")
+	tracer, err := NewFileTracer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	session := Session{
+		Transport: NewKeithley2400Simulator(Resistor{Resistance: 1e3}),
+		Observer:  MultiObserver(tracer, &SlogObserver{Logger: logger}),
+	}
+	err = session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetErrorQuery("SYST:ERR?")
+	session.Write("FOO")
+	session.ReadBytes()
+	session.Close()
+	tracer.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := ReadTranscript(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, event := range events {
+		ops = append(ops, fmt.Sprintf("%s %s", event.Op, event.Data))
+	}
+	expected := []string{
+		"OPEN ",
+		"WRITE *IDN?",
+		"READ KEITHLEY INSTRUMENTS INC.,MODEL 2400,1000000,C30   Mar 17 2006 09:29:29/A02  /K/J\n",
+		"WRITE FOO",
+		"WRITE SYST:ERR?",
+		"READ -113,\"Undefined header\"\n",
+		"WRITE SYST:ERR?",
+		"READ +0,\"No error\"\n",
+		"READ ",
+		"CLOSE ",
+	}
+	if strings.Join(ops, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected transcript:\n%s", strings.Join(ops, "\n"))
+	}
+	if len(events) == len(expected) && events[8].Err == nil {
+		t.Error("failed read must be traced with its error")
+	}
+
+	if !strings.Contains(logs.String(), "op=WRITE") || !strings.Contains(logs.String(), "level=WARN") {
+		t.Errorf("unexpected log:\n%s", logs.String())
+	}
+}
+
+func TestTranscriptLine(t *testing.T) {
+
+	event := IOEvent{
+		Time:       time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC),
+		Op:         IORead,
+		Data:       []byte("#14\x00\n\"x"),
+		Duration:   1500 * time.Microsecond,
+		VISAStatus: -1073807339,
+		Err:        fmt.Errorf("timeout \"expired\""),
+	}
+	parsed, err := parseTranscriptLine(strings.TrimSpace(formatTranscriptLine(event)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Time.Equal(event.Time) || parsed.Op != event.Op || !bytes.Equal(parsed.Data, event.Data) ||
+		parsed.Duration != event.Duration || parsed.VISAStatus != event.VISAStatus ||
+		parsed.Err == nil || parsed.Err.Error() != event.Err.Error() {
+		t.Errorf("transcript line round trip failed: %#v", parsed)
+	}
+
+	for _, line := range []string{"", "x WRITE 1ms \"a\"", "2024-03-01T12:00:00Z WRITE 1ms a", "2024-03-01T12:00:00Z WRITE 1ms \"a\" foo=1"} {
+		_, err := parseTranscriptLine(line)
+		if err == nil {
+			t.Errorf("%q: expected error", line)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+//go:build !visa
+
+package instruments
+
+import "github.com/pkg/errors"
+
+// VISA library isn't compiled in, discovery skips it.
+const visaAvailable = false
+
+// VISAResourceManager stands in for a session to VISA resource manager, there is none
+// without VISA library.
+type VISAResourceManager struct{}
+
+// VisaObjectWrapper is a Session over a native VISA library, without it Init fails with
+// ErrVISAUnavailable.
+type VisaObjectWrapper struct {
+	Session
+	ResourceName    string
+	ResourceManager *VISAResourceManager
+}
+
+var _ Instrument = (*VisaObjectWrapper)(nil)
+
+func (vw *VisaObjectWrapper) Init() error {
+
+	vw.Transport = newVISATransport(vw.ResourceName)
+	return vw.Session.Init()
+}
+
+// Open session to VISA resource manager, always fails without VISA library.
+func GetResourceManager() (VISAResourceManager, error) {
+	return VISAResourceManager{}, errors.Wrap(ErrVISAUnavailable, "couldn't open a session to the visa resource manager")
+}
+
+// Resources reachable only through VISA library fail to open with ErrVISAUnavailable.
+func newVISATransport(resource string) Transport {
+	return &unavailableVISATransport{resourceName: resource}
+}
+
+type unavailableVISATransport struct {
+	resourceName string
+}
+
+func (vt *unavailableVISATransport) Open() error {
+	return errors.Wrapf(ErrVISAUnavailable, "couldn't connect to \"%s\"", vt.resourceName)
+}
+
+func (vt *unavailableVISATransport) Write(data []byte) error {
+	return errors.Wrapf(ErrVISAUnavailable, "couldn't write to \"%s\"", vt.resourceName)
+}
+
+func (vt *unavailableVISATransport) Read() ([]byte, error) {
+	return nil, errors.Wrapf(ErrVISAUnavailable, "couldn't read from \"%s\"", vt.resourceName)
+}
+
+func (vt *unavailableVISATransport) Close() error {
+	return nil
+}
+
+// Find resources matching VISA viFindRsrc expression, always fails without VISA library.
+func FindVISAResources(expr string) ([]string, error) {
+	return nil, errors.Wrapf(ErrVISAUnavailable, "couldn't find \"%s\"", expr)
+}
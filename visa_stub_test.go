@@ -0,0 +1,37 @@
+//go:build !visa
+
+package instruments
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestVISAUnavailable(t *testing.T) {
+
+	_, err := Open("GPIB0::24::INSTR")
+	if !errors.Is(err, ErrVISAUnavailable) {
+		t.Errorf("expected ErrVISAUnavailable for GPIB resource, got %v", err)
+	}
+	_, err = FindVISAResources("?*INSTR")
+	if !errors.Is(err, ErrVISAUnavailable) {
+		t.Errorf("expected ErrVISAUnavailable from FindVISAResources, got %v", err)
+	}
+	rm, err := GetResourceManager()
+	if !errors.Is(err, ErrVISAUnavailable) {
+		t.Errorf("expected ErrVISAUnavailable from GetResourceManager, got %v", err)
+	}
+	wrapper := VisaObjectWrapper{ResourceName: "GPIB0::24::INSTR", ResourceManager: &rm}
+	err = wrapper.Init()
+	if !errors.Is(err, ErrVISAUnavailable) {
+		t.Errorf("expected ErrVISAUnavailable from VisaObjectWrapper, got %v", err)
+	}
+
+	// VISA isn't an enabled method of discovery without VISA library
+	discovery := Discovery{SkipVXI11: true, SkipMDNS: true}
+	instruments, err := discovery.Run(context.Background())
+	if err != nil || len(instruments) != 0 {
+		t.Errorf("unexpected discovery result %v, %v", instruments, err)
+	}
+}
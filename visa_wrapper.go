@@ -1,142 +1,200 @@
+//go:build visa
+
 package instruments
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jpoirier/visa"
-	"github.com/pkg/errors"
 )
 
-const bufferSize = 1024
+// VISA library is compiled in, discovery uses it.
+const visaAvailable = true
+
+const visaDefaultTimeout = 2000 // ms, VI_ATTR_TMO_VALUE after viOpen
 
+// VISAResourceManager is a session to VISA resource manager.
+type VISAResourceManager = visa.Session
+
+// VisaObjectWrapper is a Session over a native VISA library.
 type VisaObjectWrapper struct {
+	Session
 	ResourceName    string
-	ResourceManager *visa.Session
-	instr           *visa.Object
-	errorQuery      string
-	info            map[string]string
+	ResourceManager *VISAResourceManager
 }
 
+var _ Instrument = (*VisaObjectWrapper)(nil)
+
 func (vw *VisaObjectWrapper) Init() error {
 
-	instr, visaStatus := vw.ResourceManager.Open(vw.ResourceName, uint32(visa.NULL), uint32(visa.NULL))
+	vw.Transport = &visaTransport{resourceName: vw.ResourceName, resourceManager: vw.ResourceManager}
+	return vw.Session.Init()
+}
+
+// Transport for resource reachable only through VISA library.
+func newVISATransport(resource string) Transport {
+	return &visaTransport{resourceName: resource}
+}
+
+// visaTransport implements Transport with VISA viOpen/viWrite/viRead.
+// Without resourceManager it opens the default one and closes it together with instr.
+type visaTransport struct {
+	resourceName    string
+	resourceManager *visa.Session
+	instr           *visa.Object
+	ownRM           bool
+	status          visa.Status // status of the last operation
+}
+
+func (vt *visaTransport) Open() error {
+
+	if vt.resourceManager == nil {
+		rm, err := GetResourceManager()
+		if err != nil {
+			return err
+		}
+		vt.resourceManager = &rm
+		vt.ownRM = true
+	}
+	instr, visaStatus := vt.resourceManager.Open(vt.resourceName, uint32(visa.NULL), uint32(visa.NULL))
+	vt.status = visaStatus
 	if visaStatus != visa.SUCCESS {
-		return fmt.Errorf("an VISA error occurred (%d) while connect to \"%s\"", visaStatus, vw.ResourceName)
+		vt.closeRM()
+		return fmt.Errorf("an VISA error occurred (%d) while connect to \"%s\"", visaStatus, vt.resourceName)
 	}
-
-	vw.instr = &instr
-	response, err := vw.Query("*IDN?")
-	if err != nil {
-		return err
-	}
-	splitResponse := strings.Split(response, ",")
-	vw.info = make(map[string]string, 4)
-	vw.info["Manufacturer"] = splitResponse[0]
-	vw.info["Model"] = splitResponse[1]
-	vw.info["Serial"] = splitResponse[2]
-	vw.info["Version"] = splitResponse[3]
+	vt.instr = &instr
 	return nil
 }
 
-// Write command to instr and read response
-func (vw *VisaObjectWrapper) Query(cmd string) (string, error) {
+func (vt *visaTransport) Write(data []byte) error {
 
-	_, visaStatus := vw.instr.Write([]byte(cmd), uint32(len(cmd)))
+	_, visaStatus := vt.instr.Write(data, uint32(len(data)))
+	vt.status = visaStatus
 	if visaStatus != visa.SUCCESS {
-		statusDesc, _ := vw.instr.StatusDesc(visaStatus)
-		visaErr := fmt.Errorf("%d, %s", visaStatus, statusDesc[0:strings.Index(statusDesc, ".")])
-		context := fmt.Sprintf("an VISA error occurred while writing \"%s\" command", cmd)
-		return "", errors.Wrap(visaErr, context)
+		return vt.visaError(visaStatus)
 	}
+	return nil
+}
 
-	bytes, _, visaStatus := vw.instr.Read(bufferSize)
-	if visaStatus != visa.SUCCESS {
-		instrErr := vw.CheckErrors()
-		if instrErr != nil {
-			context := fmt.Sprintf("an instr error occurred while reading response after \"%s\" command", cmd)
-			return "", errors.Wrap(instrErr, context)
-		} else {
-			statusDesc, _ := vw.instr.StatusDesc(visaStatus)
-			visaErr := fmt.Errorf("%d, %s", visaStatus, statusDesc[0:strings.Index(statusDesc, ".")])
-			context := fmt.Sprintf("an VISA error occurred while reading response after \"%s\" command", cmd)
-			return "", errors.Wrap(visaErr, context)
+func (vt *visaTransport) Read() ([]byte, error) {
+
+	// Buffer is filled without END, the rest of response is read in the next chunks
+	var msg []byte
+	for {
+		bytes, _, visaStatus := vt.instr.Read(bufferSize)
+		vt.status = visaStatus
+		msg = append(msg, bytes...)
+		if visaStatus == visa.SUCCESS_MAX_CNT {
+			continue
 		}
+		if visaStatus != visa.SUCCESS {
+			return nil, vt.visaError(visaStatus)
+		}
+		return msg, nil
 	}
-	response := string(bytes)
-	if len(response) == 0 {
-		return response, fmt.Errorf("get empty response from instr after \"%s\" command", cmd)
-	}
-	return response[0:strings.Index(response, "\n")], nil
 }
 
-// Write command to instr
-func (vw *VisaObjectWrapper) Write(cmd string) error {
+func (vt *visaTransport) Close() error {
 
-	_, visaStatus := vw.instr.Write([]byte(cmd), uint32(len(cmd)))
-	if visaStatus != visa.SUCCESS {
-		statusDesc, _ := vw.instr.StatusDesc(visaStatus)
-		visaErr := fmt.Errorf("%d, %s", visaStatus, statusDesc[0:strings.Index(statusDesc, ".")])
-		context := fmt.Sprintf("an VISA error occurred while writing \"%s\" command", cmd)
-		return errors.Wrap(visaErr, context)
+	if vt.instr == nil {
+		return nil
 	}
-	instrErr := vw.CheckErrors()
-	if instrErr != nil {
-		context := fmt.Sprintf("an instr error occurred while writing \"%s\" command", cmd)
-		return errors.Wrap(instrErr, context)
+	visaStatus := vt.instr.Close()
+	vt.status = visaStatus
+	vt.instr = nil
+	vt.closeRM()
+	if visaStatus != visa.SUCCESS {
+		return fmt.Errorf("an VISA error occurred (%d) while closing \"%s\"", visaStatus, vt.resourceName)
 	}
 	return nil
 }
 
-// Write command to instr without instr error check (not recommended)
-func (vw *VisaObjectWrapper) WriteWithoutCheck(cmd string) error {
+// Device clear (viClear).
+func (vt *visaTransport) Clear() error {
 
-	_, visaStatus := vw.instr.Write([]byte(cmd), uint32(len(cmd)))
+	if vt.instr == nil {
+		return fmt.Errorf("VISA session to \"%s\" is not open", vt.resourceName)
+	}
+	visaStatus := vt.instr.Clear()
 	if visaStatus != visa.SUCCESS {
-		statusDesc, _ := vw.instr.StatusDesc(visaStatus)
-		visaErr := fmt.Errorf("%d, %s", visaStatus, statusDesc[0:strings.Index(statusDesc, ".")])
-		context := fmt.Sprintf("an VISA error occurred while writing \"%s\" command", cmd)
-		return errors.Wrap(visaErr, context)
+		return vt.visaError(visaStatus)
 	}
 	return nil
 }
 
-// Check instrument errors
-func (vw *VisaObjectWrapper) CheckErrors() error {
+// Read status byte (viReadSTB), serial poll on GPIB.
+func (vt *visaTransport) ReadSTB() (byte, error) {
+
+	if vt.instr == nil {
+		return 0, fmt.Errorf("VISA session to \"%s\" is not open", vt.resourceName)
+	}
+	stb, visaStatus := vt.instr.ReadSTB()
+	if visaStatus != visa.SUCCESS {
+		return 0, vt.visaError(visaStatus)
+	}
+	return byte(stb), nil
+}
 
-	res, _ := vw.Query(vw.errorQuery + ";*CLS")
-	res = strings.ReplaceAll(res, "\"", "")
-	splitRes := strings.Split(res, ",")
-	code, _ := strconv.Atoi(splitRes[0])
+// Bound I/O by t with VI_ATTR_TMO_VALUE, VISA I/O in progress can't be interrupted.
+func (vt *visaTransport) SetDeadline(t time.Time) error {
 
-	if code != 0 {
-		return fmt.Errorf(res)
+	if vt.instr == nil {
+		return nil
+	}
+	timeout := uint32(visaDefaultTimeout)
+	if !t.IsZero() {
+		timeout = uint32(max(time.Until(t), 0) / time.Millisecond)
+	}
+	visaStatus := vt.instr.SetAttribute(visa.ATTR_TMO_VALUE, timeout)
+	if visaStatus != visa.SUCCESS {
+		return vt.visaError(visaStatus)
 	}
 	return nil
 }
 
-// Get instrument info about Manufacturer, Model, Serial, Version
-func (vw *VisaObjectWrapper) GetInfo() map[string]string {
-	return vw.info
+func (vt *visaTransport) lastVISAStatus() VISAStatus {
+	return VISAStatus(vt.status)
+}
+
+func (vt *visaTransport) closeRM() {
+
+	if vt.ownRM {
+		vt.resourceManager.Close()
+		vt.resourceManager = nil
+		vt.ownRM = false
+	}
+}
+
+func (vt *visaTransport) visaError(visaStatus visa.Status) error {
+
+	statusDesc, _ := vt.instr.StatusDesc(visaStatus)
+	if i := strings.Index(statusDesc, "."); i >= 0 {
+		statusDesc = statusDesc[0:i]
+	}
+	return &VISAError{Status: visaStatus, Description: statusDesc}
+}
+
+// VISAError is a failed VISA operation status.
+type VISAError struct {
+	Status      visa.Status
+	Description string
+}
+
+func (e *VISAError) Error() string {
+	return fmt.Sprintf("an VISA error occurred: %d, %s", e.Status, e.Description)
 }
 
-// Cast instrument info to string
-func (vw *VisaObjectWrapper) String() string {
-	infoStr := fmt.Sprintf(
-		"Manufacturer:\t%s\n"+
-			"Model:\t\t%s\n"+
-			"Serial:\t\t%s\n"+
-			"Version:\t%s\n",
-		vw.info["Manufacturer"], vw.info["Model"], vw.info["Serial"], vw.info["Version"])
-	return infoStr
+func (e *VISAError) Timeout() bool {
+	return e.Status == visa.ERROR_TMO
 }
 
-func (vw *VisaObjectWrapper) SetErrorQuery(query string) {
-	vw.errorQuery = query
+func (e *VISAError) ConnectionLost() bool {
+	return e.Status == visa.ERROR_CONN_LOST
 }
 
-func GetResourceManager() (visa.Session, error) {
+func GetResourceManager() (VISAResourceManager, error) {
 
 	rm, visaStatus := visa.OpenDefaultRM()
 	if visaStatus != visa.SUCCESS {
@@ -144,3 +202,31 @@ func GetResourceManager() (visa.Session, error) {
 	}
 	return rm, nil
 }
+
+// Find resources matching VISA viFindRsrc expression, e.g. "?*INSTR".
+func FindVISAResources(expr string) ([]string, error) {
+
+	rm, err := GetResourceManager()
+	if err != nil {
+		return nil, err
+	}
+	defer rm.Close()
+
+	list, count, resource, visaStatus := rm.FindRsrc(expr)
+	if visaStatus == visa.ERROR_RSRC_NFOUND {
+		return nil, nil
+	}
+	if visaStatus < visa.SUCCESS {
+		return nil, fmt.Errorf("an VISA error occurred (%d) while find \"%s\"", visaStatus, expr)
+	}
+	defer list.Close()
+	resources := []string{resource}
+	for i := uint32(1); i < count; i++ {
+		resource, visaStatus = list.FindNext()
+		if visaStatus < visa.SUCCESS {
+			return resources, fmt.Errorf("an VISA error occurred (%d) while find \"%s\"", visaStatus, expr)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
@@ -0,0 +1,401 @@
+package instruments
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VXI-11 core channel, see VXIbus TCP/IP Instrument Protocol Specification VXI-11 rev 1.0
+const (
+	vxi11CoreProgram = 0x0607AF
+	vxi11CoreVersion = 1
+
+	vxi11CreateLink    = 10
+	vxi11DeviceWrite   = 11
+	vxi11DeviceRead    = 12
+	vxi11DeviceReadStb = 13
+	vxi11DeviceTrigger = 14
+	vxi11DeviceClear   = 15
+	vxi11DeviceRemote  = 16
+	vxi11DeviceLocal   = 17
+	vxi11DeviceLock    = 18
+	vxi11DeviceUnlock  = 19
+	vxi11DestroyLink   = 23
+
+	vxi11FlagWaitLock   = 0x01
+	vxi11FlagEnd        = 0x08
+	vxi11FlagTermChrSet = 0x80
+
+	vxi11ReasonReqCnt = 0x01
+	vxi11ReasonChr    = 0x02
+	vxi11ReasonEnd    = 0x04
+
+	vxi11ReadSize       = 0x10000
+	vxi11DefaultTimeout = 10 * time.Second
+)
+
+var vxi11Errors = map[uint32]string{
+	1:  "syntax error",
+	3:  "device not accessible",
+	4:  "invalid link identifier",
+	5:  "parameter error",
+	6:  "channel not established",
+	8:  "operation not supported",
+	9:  "out of resources",
+	11: "device locked by another link",
+	12: "no lock held by this link",
+	15: "I/O timeout",
+	17: "I/O error",
+	21: "invalid address",
+	23: "abort",
+	29: "channel already established",
+}
+
+// VXI11Error is a non-zero Device_ErrorCode returned by a VXI-11 server.
+type VXI11Error struct {
+	Procedure uint32
+	Code      uint32
+}
+
+func (e *VXI11Error) Error() string {
+	msg, ok := vxi11Errors[e.Code]
+	if !ok {
+		msg = "unknown error"
+	}
+	return fmt.Sprintf("VXI-11 error %d (%s) in procedure %d", e.Code, msg, e.Procedure)
+}
+
+// VXI11Transport implements Transport over VXI-11 (TCPIP::host::inst0::INSTR) without VISA.
+type VXI11Transport struct {
+	Host        string        // instrument host name or IP address
+	Device      string        // logical device name, "inst0" by default
+	Port        int           // core channel port, asked from portmapper if zero
+	Timeout     time.Duration // I/O timeout, 10 s by default
+	LockTimeout time.Duration // time to wait for a lock held by another link
+	TermChar    byte          // read termination character, 0 to read until END (default)
+	mu          sync.Mutex    // guards conn replaced while SetDeadline may run concurrently
+	conn        net.Conn
+	rpc         *rpcClient
+	link        uint32
+	maxRecvSize uint32
+	deadline    ioDeadline
+	broken      bool
+	locked      bool // device lock is held, it is acquired again on a recreated link
+}
+
+func (vt *VXI11Transport) Open() error {
+
+	if vt.Device == "" {
+		vt.Device = "inst0"
+	}
+	if vt.Timeout == 0 {
+		vt.Timeout = vxi11DefaultTimeout
+	}
+
+	port := vt.Port
+	if port == 0 {
+		pmConn, err := net.DialTimeout("tcp", net.JoinHostPort(vt.Host, strconv.Itoa(portmapperPort)), vt.Timeout)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't connect to portmapper of \"%s\"", vt.Host)
+		}
+		pmConn.SetDeadline(time.Now().Add(vt.Timeout))
+		port, err = getPort(pmConn, vxi11CoreProgram, vxi11CoreVersion)
+		pmConn.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(vt.Host, strconv.Itoa(port)), vt.Timeout)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't connect to VXI-11 core channel of \"%s\"", vt.Host)
+	}
+	vt.setConn(conn)
+	vt.rpc = &rpcClient{conn: conn, program: vxi11CoreProgram, version: vxi11CoreVersion}
+	vt.broken = false
+
+	args := xdrWriter{}
+	args.uint32(uint32(time.Now().UnixNano())) // client id
+	args.bool(false)                           // lock device
+	args.uint32(vt.milliseconds(vt.LockTimeout))
+	args.string(vt.Device)
+	reply, err := vt.call(vxi11CreateLink, args.buf, vt.Timeout)
+	if err != nil {
+		vt.conn.Close()
+		vt.setConn(nil)
+		return errors.Wrapf(err, "couldn't create link to \"%s\" on \"%s\"", vt.Device, vt.Host)
+	}
+	vt.link = reply.uint32()
+	reply.uint32() // abort port
+	vt.maxRecvSize = reply.uint32()
+	if vt.maxRecvSize == 0 {
+		vt.maxRecvSize = bufferSize
+	}
+	return nil
+}
+
+// Write message with END flag set on its last chunk.
+func (vt *VXI11Transport) Write(data []byte) error {
+
+	if vt.conn == nil {
+		return fmt.Errorf("VXI-11 link to \"%s\" is not open", vt.Host)
+	}
+	for {
+		chunk := data
+		flags := uint32(vxi11FlagEnd)
+		if uint32(len(chunk)) > vt.maxRecvSize {
+			chunk = chunk[0:vt.maxRecvSize]
+			flags = 0
+		}
+		args := xdrWriter{}
+		args.uint32(vt.link)
+		args.uint32(vt.milliseconds(vt.Timeout))
+		args.uint32(vt.milliseconds(vt.LockTimeout))
+		args.uint32(flags | vt.lockFlag())
+		args.opaque(chunk)
+		reply, err := vt.call(vxi11DeviceWrite, args.buf, vt.Timeout)
+		if err != nil {
+			return err
+		}
+		size := reply.uint32()
+		if reply.err != nil {
+			return errors.Wrap(reply.err, "malformed device_write reply")
+		}
+		if size == 0 {
+			return fmt.Errorf("VXI-11 link to \"%s\" accepted no data", vt.Host)
+		}
+		if size > uint32(len(chunk)) {
+			size = uint32(len(chunk))
+		}
+		data = data[size:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// Read message until END indicator (or TermChar, if set).
+func (vt *VXI11Transport) Read() ([]byte, error) {
+
+	if vt.conn == nil {
+		return nil, fmt.Errorf("VXI-11 link to \"%s\" is not open", vt.Host)
+	}
+	var msg []byte
+	for {
+		flags := vt.lockFlag()
+		termChar := uint32(0)
+		if vt.TermChar != 0 {
+			flags |= vxi11FlagTermChrSet
+			termChar = uint32(vt.TermChar)
+		}
+		args := xdrWriter{}
+		args.uint32(vt.link)
+		args.uint32(vxi11ReadSize)
+		args.uint32(vt.milliseconds(vt.Timeout))
+		args.uint32(vt.milliseconds(vt.LockTimeout))
+		args.uint32(flags)
+		args.uint32(termChar)
+		reply, err := vt.call(vxi11DeviceRead, args.buf, vt.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		reason := reply.uint32()
+		msg = append(msg, reply.opaque()...)
+		if reply.err != nil {
+			return nil, errors.Wrap(reply.err, "malformed device_read reply")
+		}
+		if reason&(vxi11ReasonEnd|vxi11ReasonChr) != 0 {
+			return msg, nil
+		}
+	}
+}
+
+func (vt *VXI11Transport) Close() error {
+
+	if vt.conn == nil {
+		return nil
+	}
+	// A broken link isn't reopened only to be destroyed, closing the socket drops it
+	var err error
+	if !vt.broken {
+		args := xdrWriter{}
+		args.uint32(vt.link)
+		_, err = vt.call(vxi11DestroyLink, args.buf, vt.Timeout)
+	}
+	closeErr := vt.conn.Close()
+	vt.setConn(nil)
+	vt.locked = false
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Device clear (device_clear).
+func (vt *VXI11Transport) Clear() error {
+	_, err := vt.generic(vxi11DeviceClear)
+	return err
+}
+
+// Group execute trigger (device_trigger).
+func (vt *VXI11Transport) Trigger() error {
+	_, err := vt.generic(vxi11DeviceTrigger)
+	return err
+}
+
+// Read status byte (device_readstb).
+func (vt *VXI11Transport) ReadSTB() (byte, error) {
+
+	reply, err := vt.generic(vxi11DeviceReadStb)
+	if err != nil {
+		return 0, err
+	}
+	return byte(reply.uint32()), nil
+}
+
+// Put instr in remote state (device_remote).
+func (vt *VXI11Transport) Remote() error {
+	_, err := vt.generic(vxi11DeviceRemote)
+	return err
+}
+
+// Return instr to local state (device_local).
+func (vt *VXI11Transport) Local() error {
+	_, err := vt.generic(vxi11DeviceLocal)
+	return err
+}
+
+// Acquire exclusive device lock, waiting up to LockTimeout if it is held by another link.
+func (vt *VXI11Transport) Lock() error {
+
+	if vt.conn == nil {
+		return fmt.Errorf("VXI-11 link to \"%s\" is not open", vt.Host)
+	}
+	err := vt.lockLink()
+	if err != nil {
+		return err
+	}
+	vt.locked = true
+	return nil
+}
+
+// Release device lock.
+func (vt *VXI11Transport) Unlock() error {
+
+	if vt.conn == nil {
+		return fmt.Errorf("VXI-11 link to \"%s\" is not open", vt.Host)
+	}
+	args := xdrWriter{}
+	args.uint32(vt.link)
+	_, err := vt.call(vxi11DeviceUnlock, args.buf, vt.Timeout)
+	if err != nil {
+		return err
+	}
+	vt.locked = false
+	return nil
+}
+
+// Call device_lock for the current link.
+func (vt *VXI11Transport) lockLink() error {
+
+	args := xdrWriter{}
+	args.uint32(vt.link)
+	args.uint32(vxi11FlagWaitLock)
+	args.uint32(vt.milliseconds(vt.LockTimeout))
+	_, err := vt.call(vxi11DeviceLock, args.buf, vt.Timeout+vt.LockTimeout)
+	return err
+}
+
+// Call procedure with Device_GenericParms arguments.
+func (vt *VXI11Transport) generic(procedure uint32) (*xdrReader, error) {
+
+	if vt.conn == nil {
+		return nil, fmt.Errorf("VXI-11 link to \"%s\" is not open", vt.Host)
+	}
+	args := xdrWriter{}
+	args.uint32(vt.link)
+	args.uint32(vt.lockFlag())
+	args.uint32(vt.milliseconds(vt.LockTimeout))
+	args.uint32(vt.milliseconds(vt.Timeout))
+	return vt.call(procedure, args.buf, vt.Timeout)
+}
+
+// Bound I/O by t, I/O in progress is interrupted if t is in the past.
+func (vt *VXI11Transport) SetDeadline(t time.Time) error {
+
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	if vt.conn == nil {
+		return vt.deadline.setLimit(t, nil)
+	}
+	return vt.deadline.setLimit(t, vt.conn.SetDeadline)
+}
+
+// Call core channel procedure and check Device_ErrorCode (first field of every reply).
+// A call interrupted in the middle of RPC record breaks the stream, so the link is
+// recreated before the next call. Device lock held by the old link is lost with it, so
+// it is acquired again for the new link. If that fails, the call is not made and the
+// returned error reports the lost lock; the caller has to Lock again.
+func (vt *VXI11Transport) call(procedure uint32, args []byte, timeout time.Duration) (*xdrReader, error) {
+
+	if vt.broken && procedure != vxi11CreateLink {
+		vt.conn.Close()
+		vt.setConn(nil)
+		err := vt.Open()
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't recreate broken VXI-11 link")
+		}
+		// Arguments were encoded for the old link
+		binary.BigEndian.PutUint32(args, vt.link)
+		if vt.locked && procedure != vxi11DeviceLock {
+			err = vt.lockLink()
+			if err != nil {
+				vt.locked = false
+				return nil, errors.Wrap(err, "device lock is lost with broken VXI-11 link")
+			}
+		}
+	}
+	// Leave the server a chance to report its own I/O timeout first
+	err := vt.deadline.apply(timeout+time.Second, vt.conn.SetDeadline)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := vt.rpc.call(procedure, args)
+	if err != nil {
+		vt.broken = true
+		return nil, err
+	}
+	code := reply.uint32()
+	if reply.err != nil {
+		return nil, errors.Wrap(reply.err, "malformed VXI-11 reply")
+	}
+	if code != 0 {
+		return nil, &VXI11Error{Procedure: procedure, Code: code}
+	}
+	return reply, nil
+}
+
+// Replace the connection, SetDeadline reads it from other goroutines.
+func (vt *VXI11Transport) setConn(conn net.Conn) {
+
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.conn = conn
+}
+
+func (vt *VXI11Transport) lockFlag() uint32 {
+	if vt.LockTimeout > 0 {
+		return vxi11FlagWaitLock
+	}
+	return 0
+}
+
+func (vt *VXI11Transport) milliseconds(d time.Duration) uint32 {
+	return uint32(d / time.Millisecond)
+}
@@ -0,0 +1,340 @@
+package instruments
+
+import (
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// vxi11Server is a local VXI-11 core channel stand-in with a single SCPI responder.
+type vxi11Server struct {
+	mu        sync.Mutex
+	responses map[string]string
+	output    []byte
+	lockOwner uint32
+	nextLink  uint32
+	remote    bool
+	cleared   int
+	triggered int
+	stalled   bool // device_write accepts no data
+}
+
+func startVXI11Server(t *testing.T, responses map[string]string) (*vxi11Server, int) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv := &vxi11Server{responses: responses}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv, ln.Addr().(*net.TCPAddr).Port
+}
+
+func (srv *vxi11Server) serve(conn net.Conn) {
+
+	// Lost connection destroys its links and releases their lock
+	var links []uint32
+	defer func() {
+		srv.mu.Lock()
+		if slices.Contains(links, srv.lockOwner) {
+			srv.lockOwner = 0
+		}
+		srv.mu.Unlock()
+	}()
+	defer conn.Close()
+	for {
+		record, err := readRecord(conn)
+		if err != nil {
+			return
+		}
+		call := &xdrReader{buf: record}
+		xid := call.uint32()
+		call.uint32() // msg type
+		call.uint32() // rpc version
+		call.uint32() // program
+		call.uint32() // version
+		procedure := call.uint32()
+		call.uint32() // credentials
+		call.opaque()
+		call.uint32() // verifier
+		call.opaque()
+
+		reply := xdrWriter{}
+		reply.uint32(xid)
+		reply.uint32(rpcReply)
+		reply.uint32(rpcMsgAccepted)
+		reply.uint32(0)
+		reply.uint32(0)
+		reply.uint32(rpcSuccess)
+		srv.handle(procedure, call, &reply)
+		if procedure == vxi11CreateLink {
+			links = append(links, binary.BigEndian.Uint32(reply.buf[len(reply.buf)-12:]))
+		}
+		if writeRecord(conn, reply.buf) != nil {
+			return
+		}
+	}
+}
+
+func (srv *vxi11Server) handle(procedure uint32, args *xdrReader, reply *xdrWriter) {
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if procedure == vxi11CreateLink {
+		srv.nextLink++
+		reply.uint32(0)
+		reply.uint32(srv.nextLink)
+		reply.uint32(0)
+		reply.uint32(32) // small max_recv_size to exercise chunked writes
+		return
+	}
+
+	link := args.uint32()
+	if procedure == vxi11DeviceLock {
+		args.uint32() // flags
+		deadline := time.Now().Add(time.Duration(args.uint32()) * time.Millisecond)
+		for srv.lockOwner != 0 && srv.lockOwner != link && time.Now().Before(deadline) {
+			srv.mu.Unlock()
+			time.Sleep(time.Millisecond)
+			srv.mu.Lock()
+		}
+	}
+	if srv.lockOwner != 0 && srv.lockOwner != link &&
+		procedure != vxi11DestroyLink && procedure != vxi11DeviceUnlock {
+		reply.uint32(11)
+		return
+	}
+
+	switch procedure {
+	case vxi11DeviceWrite:
+		args.uint32() // io timeout
+		args.uint32() // lock timeout
+		args.uint32() // flags
+		data := args.opaque()
+		if srv.stalled {
+			reply.uint32(0)
+			reply.uint32(0)
+			return
+		}
+		srv.output = append(srv.output, data...)
+		cmd := string(srv.output)
+		if response, ok := srv.responses[cmd]; ok {
+			srv.output = []byte(response + "\n")
+		} else if strings.HasSuffix(cmd, "?") {
+			srv.output = nil
+		}
+		reply.uint32(0)
+		reply.uint32(uint32(len(data)))
+	case vxi11DeviceRead:
+		requestSize := args.uint32()
+		if len(srv.output) == 0 {
+			reply.uint32(15)
+			return
+		}
+		// Return at most 10 bytes per call to exercise the read loop
+		n := min(len(srv.output), 10, int(requestSize))
+		reason := uint32(0)
+		if n == len(srv.output) {
+			reason = vxi11ReasonEnd
+		}
+		reply.uint32(0)
+		reply.uint32(reason)
+		reply.opaque(srv.output[0:n])
+		srv.output = srv.output[n:]
+	case vxi11DeviceReadStb:
+		reply.uint32(0)
+		reply.uint32(0x10)
+	case vxi11DeviceClear:
+		srv.output = nil
+		srv.cleared++
+		reply.uint32(0)
+	case vxi11DeviceTrigger:
+		srv.triggered++
+		reply.uint32(0)
+	case vxi11DeviceRemote, vxi11DeviceLocal:
+		srv.remote = procedure == vxi11DeviceRemote
+		reply.uint32(0)
+	case vxi11DeviceLock:
+		srv.lockOwner = link
+		reply.uint32(0)
+	case vxi11DeviceUnlock:
+		if srv.lockOwner != link {
+			reply.uint32(12)
+			return
+		}
+		srv.lockOwner = 0
+		reply.uint32(0)
+	case vxi11DestroyLink:
+		if srv.lockOwner == link {
+			srv.lockOwner = 0
+		}
+		reply.uint32(0)
+	default:
+		reply.uint32(8)
+	}
+}
+
+func TestVXI11Transport(t *testing.T) {
+
+	srv, port := startVXI11Server(t, map[string]string{
+		"*IDN?":     "Agilent Technologies,34980A,MY44001234,2.41-2.41-2.41-2.41",
+		"SYST:ERR?": "+0,\"No error\"",
+	})
+
+	session := Session{Transport: &VXI11Transport{Host: "127.0.0.1", Port: port, Timeout: time.Second}}
+	err := session.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.SetErrorQuery("SYST:ERR?")
+
+	if session.GetInfo().Serial != "MY44001234" {
+		t.Errorf("unexpected serial %q", session.GetInfo().Serial)
+	}
+	err = session.Write("ROUT:OPEN:ALL ALL;" + strings.Repeat("*OPC;", 20) + "*OPC")
+	if err != nil {
+		t.Error(err)
+	}
+
+	vt := session.Transport.(*VXI11Transport)
+	if err = vt.Remote(); err != nil || !srv.remote {
+		t.Errorf("remote failed: %v", err)
+	}
+	if err = vt.Local(); err != nil || srv.remote {
+		t.Errorf("local failed: %v", err)
+	}
+	if err = vt.Trigger(); err != nil || srv.triggered != 1 {
+		t.Errorf("trigger failed: %v", err)
+	}
+	if err = vt.Clear(); err != nil || srv.cleared != 1 {
+		t.Errorf("clear failed: %v", err)
+	}
+	if stb, err := vt.ReadSTB(); err != nil || stb != 0x10 {
+		t.Errorf("readstb failed: %d, %v", stb, err)
+	}
+
+	// A second link must be rejected while the first one holds the lock
+	if err = vt.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	other := &VXI11Transport{Host: "127.0.0.1", Port: port, Timeout: time.Second}
+	if err = other.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	err = other.Write([]byte("*RST"))
+	if vxiErr, ok := err.(*VXI11Error); !ok || vxiErr.Code != 11 {
+		t.Errorf("expected device locked error, got %v", err)
+	}
+	if err = vt.Unlock(); err != nil {
+		t.Error(err)
+	}
+	if err = other.Write([]byte("*RST")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVXI11TransportFailures(t *testing.T) {
+
+	srv, port := startVXI11Server(t, nil)
+	vt := &VXI11Transport{Host: "127.0.0.1", Port: port, Timeout: time.Second}
+	err := vt.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deadline set from another goroutine while the broken link is recreated
+	vt.broken = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			vt.SetDeadline(time.Time{})
+		}
+	}()
+	err = vt.Write([]byte("*RST"))
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	srv.stalled = true
+	srv.mu.Unlock()
+	err = vt.Write([]byte("*RST"))
+	if err == nil || !strings.Contains(err.Error(), "accepted no data") {
+		t.Errorf("expected error for write accepting no data, got %v", err)
+	}
+
+	// Broken link to a vanished instr is closed without recreating it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vt.Port = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	vt.broken = true
+	err = vt.Close()
+	if err != nil {
+		t.Errorf("unexpected close error %v", err)
+	}
+	srv.mu.Lock()
+	links := srv.nextLink
+	srv.mu.Unlock()
+	if links != 2 || vt.conn != nil {
+		t.Errorf("broken link must be closed without reopening, %d links created", links)
+	}
+}
+
+func TestVXI11TransportRelock(t *testing.T) {
+
+	srv, port := startVXI11Server(t, nil)
+	vt := &VXI11Transport{Host: "127.0.0.1", Port: port, Timeout: time.Second,
+		LockTimeout: 200 * time.Millisecond}
+	err := vt.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vt.Close()
+	if err = vt.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recreated link takes the lock over before the call
+	vt.broken = true
+	if err = vt.Write([]byte("*RST")); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	owner := srv.lockOwner
+	srv.mu.Unlock()
+	if owner != vt.link || vt.link == 1 {
+		t.Errorf("lock is held by link %d instead of recreated link %d", owner, vt.link)
+	}
+
+	// Lock held by another link can't be acquired again, the call reports it
+	srv.mu.Lock()
+	srv.lockOwner = 100
+	srv.mu.Unlock()
+	vt.broken = true
+	err = vt.Write([]byte("*RST"))
+	if err == nil || !strings.Contains(err.Error(), "lock is lost") || vt.locked {
+		t.Errorf("expected lost lock error, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package instruments
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Minimal XDR (RFC 4506) and ONC RPC over TCP (RFC 5531) used by the VXI-11 transport.
+
+const (
+	rpcCall           = 0
+	rpcReply          = 1
+	rpcVersion        = 2
+	rpcMsgAccepted    = 0
+	rpcSuccess        = 0
+	rpcLastFragment   = 0x80000000
+	portmapperPort    = 111
+	portmapperProgram = 100000
+	portmapperVersion = 2
+	portmapperGetPort = 3
+	ipProtoTCP        = 6
+	ipProtoUDP        = 17
+)
+
+type xdrWriter struct {
+	buf []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+func (w *xdrWriter) bool(v bool) {
+	if v {
+		w.uint32(1)
+	} else {
+		w.uint32(0)
+	}
+}
+
+func (w *xdrWriter) opaque(data []byte) {
+	w.uint32(uint32(len(data)))
+	w.buf = append(w.buf, data...)
+	for len(w.buf)%4 != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *xdrWriter) string(s string) {
+	w.opaque([]byte(s))
+}
+
+type xdrReader struct {
+	buf []byte
+	err error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 4 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+func (r *xdrReader) opaque() []byte {
+	n := int(r.uint32())
+	if r.err != nil {
+		return nil
+	}
+	padded := (n + 3) &^ 3
+	if n < 0 || len(r.buf) < padded {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	data := r.buf[0:n]
+	r.buf = r.buf[padded:]
+	return data
+}
+
+func (r *xdrReader) string() string {
+	return string(r.opaque())
+}
+
+// rpcClient performs ONC RPC calls over a stream connection with record marking.
+type rpcClient struct {
+	conn    io.ReadWriter
+	program uint32
+	version uint32
+	xid     uint32
+}
+
+var rpcXid atomic.Uint32
+
+func (c *rpcClient) call(procedure uint32, args []byte) (*xdrReader, error) {
+
+	if c.xid == 0 {
+		c.xid = rpcXid.Add(1) << 16
+	}
+	c.xid++
+	err := writeRecord(c.conn, rpcCallMessage(c.xid, c.program, c.version, procedure, args))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		record, err := readRecord(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		reply, ok, err := parseRPCReply(record, c.xid, procedure)
+		if !ok {
+			continue // stale reply of an interrupted call
+		}
+		return reply, err
+	}
+}
+
+func rpcCallMessage(xid, program, version, procedure uint32, args []byte) []byte {
+
+	msg := xdrWriter{}
+	msg.uint32(xid)
+	msg.uint32(rpcCall)
+	msg.uint32(rpcVersion)
+	msg.uint32(program)
+	msg.uint32(version)
+	msg.uint32(procedure)
+	msg.uint32(0) // credentials AUTH_NONE
+	msg.uint32(0)
+	msg.uint32(0) // verifier AUTH_NONE
+	msg.uint32(0)
+	msg.buf = append(msg.buf, args...)
+	return msg.buf
+}
+
+// Parse reply to call xid, ok is false if record isn't a reply to that call.
+func parseRPCReply(record []byte, xid, procedure uint32) (reply *xdrReader, ok bool, err error) {
+
+	reply = &xdrReader{buf: record}
+	replyXid := reply.uint32()
+	if reply.uint32() != rpcReply || replyXid != xid {
+		return nil, false, nil
+	}
+	if stat := reply.uint32(); stat != rpcMsgAccepted {
+		return nil, true, fmt.Errorf("RPC call %d denied (reply status %d)", procedure, stat)
+	}
+	reply.uint32() // verifier flavor
+	reply.opaque() // verifier body
+	if stat := reply.uint32(); stat != rpcSuccess {
+		return nil, true, fmt.Errorf("RPC call %d not accepted (accept status %d)", procedure, stat)
+	}
+	if reply.err != nil {
+		return nil, true, errors.Wrap(reply.err, "malformed RPC reply")
+	}
+	return reply, true, nil
+}
+
+func writeRecord(w io.Writer, data []byte) error {
+
+	record := binary.BigEndian.AppendUint32(nil, rpcLastFragment|uint32(len(data)))
+	_, err := w.Write(append(record, data...))
+	return err
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+
+	var record []byte
+	header := make([]byte, 4)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return nil, err
+		}
+		marker := binary.BigEndian.Uint32(header)
+		fragment := make([]byte, marker&^rpcLastFragment)
+		_, err = io.ReadFull(r, fragment)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, fragment...)
+		if marker&rpcLastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// getPort asks the portmapper on host for the TCP port of RPC program.
+func getPort(conn io.ReadWriter, program, version uint32) (int, error) {
+
+	pm := rpcClient{conn: conn, program: portmapperProgram, version: portmapperVersion}
+	args := xdrWriter{}
+	args.uint32(program)
+	args.uint32(version)
+	args.uint32(ipProtoTCP)
+	args.uint32(0)
+	reply, err := pm.call(portmapperGetPort, args.buf)
+	if err != nil {
+		return 0, errors.Wrap(err, "portmapper GETPORT failed")
+	}
+	port := reply.uint32()
+	if reply.err != nil || port == 0 {
+		return 0, fmt.Errorf("RPC program %d version %d is not registered", program, version)
+	}
+	return int(port), nil
+}